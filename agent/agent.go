@@ -1,12 +1,16 @@
 package agent
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+	"sync"
 
 	"github.com/alexisbouchez/palm/provider"
+	"github.com/alexisbouchez/palm/store"
 	"github.com/alexisbouchez/palm/stream"
 	"github.com/alexisbouchez/palm/tool"
 )
@@ -15,19 +19,71 @@ type Agent interface {
 	WithProvider(provider provider.Provider) Agent
 	WithTool(tool tool.Callable) Agent
 	WithStreamHandler(handler StreamHandler) Agent
+	WithGenerationOptions(opts provider.GenerationOptions) Agent
+	WithMaxContinuations(n int) Agent
+	WithMaxSteps(n int) Agent
+	WithStopWhen(conditions ...StopCondition) Agent
+	WithToolConcurrency(n int) Agent
+	WithApprover(approver Approver) Agent
+	WithConversationStore(store store.ConversationStore, id string) Agent
+	WithToolChoice(choice provider.ToolChoice) Agent
+	WithParallelToolCalls(enabled bool) Agent
+	WithInstructions(instructions string) Agent
+	WithInstructionsFunc(fn InstructionsFunc) Agent
 	Chat(message string, writer io.Writer) error
+	ChatContext(ctx context.Context, message string, writer io.Writer) error
+	ChatMessage(ctx context.Context, message provider.Message, writer io.Writer) error
+	Resume(ctx context.Context, approvals map[string]Approval, writer io.Writer) error
+	PendingApprovals() []ApprovalRequest
+	Messages() []provider.Message
+	CheckCapabilities(ctx context.Context) error
 }
 
+// InstructionsFunc renders the system prompt for one turn. It receives the
+// request context and the tools offered to the model.
+type InstructionsFunc func(ctx context.Context, tools []provider.Tool) (string, error)
+
 type agent struct {
-	provider       provider.Provider
-	tools          []tool.Callable
-	messages       []provider.Message
-	streamHandler  StreamHandler
+	provider      provider.Provider
+	tools         []tool.Callable
+	messages      []provider.Message
+	streamHandler StreamHandler
+	options       provider.GenerationOptions
+	continuations int
+	toolChoice    *provider.ToolChoice
+	parallelCalls *bool
+	model         *provider.ModelInfo
+	checked       bool
+	instructions  InstructionsFunc
+	maxSteps      int
+	stopWhen      []StopCondition
+	concurrency   int
+	approver      Approver
+	pending       *turn
+	store         store.ConversationStore
+	conversation  string
 }
 
+var (
+	ErrOutputTruncated  = errors.New("output truncated")
+	ErrGenerationFailed = errors.New("generation failed")
+	ErrUnsupported      = errors.New("not supported by model")
+)
+
+const (
+	continuePrompt = "Continue exactly where you left off, without repeating anything."
+	// defaultMaxSteps keeps a model that never stops calling tools from
+	// looping forever.
+	defaultMaxSteps = 20
+)
+
+// New returns an agent with no provider or tools. It makes at most 20 model
+// calls per message; a turn that reaches the limit ends with a *StopError.
+// Use WithMaxSteps to change or remove the limit.
 func New() Agent {
 	return &agent{
 		messages: []provider.Message{},
+		maxSteps: defaultMaxSteps,
 	}
 }
 
@@ -46,68 +102,436 @@ func (a *agent) WithStreamHandler(handler StreamHandler) Agent {
 	return a
 }
 
+func (a *agent) WithGenerationOptions(opts provider.GenerationOptions) Agent {
+	a.options = opts
+	return a
+}
+
+// WithMaxContinuations lets the agent ask the model to carry on up to n times
+// when a text reply is cut off by the token limit, instead of failing with
+// ErrOutputTruncated.
+func (a *agent) WithMaxContinuations(n int) Agent {
+	a.continuations = n
+	return a
+}
+
+// WithMaxSteps caps the number of model calls made for a single message,
+// counting every tool step and continuation. A turn that reaches the cap
+// while the model still wants tools ends with a *StopError. It defaults to
+// 20; zero or less removes the limit.
+func (a *agent) WithMaxSteps(n int) Agent {
+	a.maxSteps = n
+	return a
+}
+
+// WithStopWhen adds conditions that end the turn early. They are checked
+// whenever the model asks for tools, and the first one to hold drops those
+// calls and makes the message fail with a *StopError.
+func (a *agent) WithStopWhen(conditions ...StopCondition) Agent {
+	a.stopWhen = append(a.stopWhen, conditions...)
+	return a
+}
+
+// WithToolConcurrency runs up to n of the tool calls from one step at the
+// same time. Results are still added to the conversation in call order, while
+// tool-output-available events are sent as each call finishes. Tools that
+// implement tool.SerialOnly run on their own. The default of 1 runs calls one
+// after another.
+func (a *agent) WithToolConcurrency(n int) Agent {
+	a.concurrency = n
+	return a
+}
+
+// WithApprover sets who decides on calls to tools that need approval.
+// Without one, such calls are denied.
+func (a *agent) WithApprover(approver Approver) Agent {
+	a.approver = approver
+	return a
+}
+
+// WithConversationStore makes the agent load the conversation with the given
+// ID before each message and save it once the turn ends, so the history
+// outlives the agent. A turn waiting for approvals is not saved until Resume
+// finishes it, so callers must not start another turn on the same ID
+// meanwhile.
+func (a *agent) WithConversationStore(store store.ConversationStore, id string) Agent {
+	a.store = store
+	a.conversation = id
+	return a
+}
+
+// WithToolChoice sets whether the model may, must or must not call tools. A
+// choice that forces a tool call only applies until the first tool step, so
+// the model can then answer with the results.
+func (a *agent) WithToolChoice(choice provider.ToolChoice) Agent {
+	a.toolChoice = &choice
+	return a
+}
+
+func (a *agent) WithParallelToolCalls(enabled bool) Agent {
+	a.parallelCalls = &enabled
+	return a
+}
+
+// WithInstructions sets the system prompt. It is sent as the first message of
+// every request but never stored in the conversation history.
+func (a *agent) WithInstructions(instructions string) Agent {
+	return a.WithInstructionsFunc(func(context.Context, []provider.Tool) (string, error) {
+		return instructions, nil
+	})
+}
+
+// WithInstructionsFunc is like WithInstructions but renders the prompt at the
+// start of each turn, so it can include the date, the user or the tools.
+func (a *agent) WithInstructionsFunc(fn InstructionsFunc) Agent {
+	a.instructions = fn
+	return a
+}
+
+// CheckCapabilities looks the provider's model up and fails if the agent
+// relies on something the model cannot do, such as calling tools. Providers
+// that cannot describe their models are assumed to support everything. It
+// runs automatically before the first message.
+func (a *agent) CheckCapabilities(ctx context.Context) error {
+	a.checked = true
+
+	lister, ok := a.provider.(provider.ModelLister)
+	if !ok {
+		return nil
+	}
+
+	info, err := lister.DescribeModel(ctx, "")
+	if err != nil {
+		if !errors.Is(err, provider.ErrNotSupported) {
+			slog.Warn("could not check model capabilities", "error", err)
+		}
+		return nil
+	}
+	a.model = info
+
+	if len(a.tools) > 0 && !info.Capabilities.FunctionCalling {
+		return fmt.Errorf("%w: %s cannot call tools", ErrUnsupported, info.ID)
+	}
+	return nil
+}
+
+func (a *agent) Messages() []provider.Message {
+	return a.messages
+}
+
 func (a *agent) Chat(message string, writer io.Writer) error {
+	return a.ChatContext(context.Background(), message, writer)
+}
+
+func (a *agent) ChatContext(ctx context.Context, message string, writer io.Writer) error {
+	return a.ChatMessage(ctx, provider.Message{Role: "user", Content: message}, writer)
+}
+
+// ChatMessage is like ChatContext but takes a full user message, which may
+// carry images or documents alongside the text.
+func (a *agent) ChatMessage(ctx context.Context, message provider.Message, writer io.Writer) error {
 	if a.provider == nil {
 		return errors.New("provider undefined")
 	}
+	if a.pending != nil {
+		return fmt.Errorf("%w: resume the previous turn first", ErrApprovalPending)
+	}
 
-	a.messages = append(a.messages, provider.Message{
-		Role:    "user",
-		Content: message,
-	})
+	if !a.checked {
+		if err := a.CheckCapabilities(ctx); err != nil {
+			return err
+		}
+	}
+	if a.model != nil && !a.model.Capabilities.Vision && hasImage(message) {
+		return fmt.Errorf("%w: %s cannot read images", ErrUnsupported, a.model.ID)
+	}
+
+	providerTools := a.buildTools()
+	system, err := a.system(ctx, providerTools)
+	if err != nil {
+		return err
+	}
+
+	if a.store != nil {
+		messages, err := a.store.Load(ctx, a.conversation)
+		if err != nil {
+			return fmt.Errorf("load conversation: %w", err)
+		}
+		a.messages = messages
+	}
+
+	if message.Role == "" {
+		message.Role = "user"
+	}
+	t := &turn{history: len(a.messages)}
+	a.messages = append(a.messages, message)
+
+	return a.save(ctx, a.run(ctx, writer, t, system, providerTools))
+}
+
+// PendingApprovals returns the tool calls the last turn is waiting on after
+// it ended with ErrApprovalPending.
+func (a *agent) PendingApprovals() []ApprovalRequest {
+	if a.pending == nil {
+		return nil
+	}
+	return a.pending.requests
+}
+
+// Resume continues a turn that ended with ErrApprovalPending. Decisions are
+// keyed by approval ID; calls without one are denied. The approved calls run
+// and the model is called again, streaming onto writer as a continuation of
+// the same message.
+func (a *agent) Resume(ctx context.Context, approvals map[string]Approval, writer io.Writer) error {
+	t := a.pending
+	if t == nil {
+		return errors.New("no pending approvals")
+	}
 
 	providerTools := a.buildTools()
+	system, err := a.system(ctx, providerTools)
+	if err != nil {
+		return err
+	}
+
+	for _, req := range t.requests {
+		approval, ok := approvals[req.ID]
+		if !ok {
+			approval = Approval{Reason: "no decision was given"}
+		}
+		t.approvals[req.ToolCall.ID] = approval
+	}
+	t.requests = nil
+	a.pending = nil
+
+	return a.save(ctx, a.run(ctx, writer, t, system, providerTools))
+}
+
+// save stores the conversation after a turn, whether or not it succeeded, and
+// passes the turn's error through. A paused turn is skipped: its tool calls
+// have no results yet, so the history would not be valid to send.
+func (a *agent) save(ctx context.Context, runErr error) error {
+	if a.store == nil || errors.Is(runErr, ErrApprovalPending) {
+		return runErr
+	}
+	// The history is saved even if the client went away mid-turn.
+	if err := a.store.Save(context.WithoutCancel(ctx), a.conversation, a.messages); err != nil {
+		return errors.Join(runErr, fmt.Errorf("save conversation: %w", err))
+	}
+	return runErr
+}
+
+func (a *agent) system(ctx context.Context, tools []provider.Tool) ([]provider.Message, error) {
+	if a.instructions == nil {
+		return nil, nil
+	}
+	instructions, err := a.instructions(ctx, tools)
+	if err != nil {
+		return nil, fmt.Errorf("render instructions: %w", err)
+	}
+	if instructions == "" {
+		return nil, nil
+	}
+	return []provider.Message{{Role: "system", Content: instructions}}, nil
+}
+
+// turn is the progress of one message across its steps, kept while the turn
+// waits for tool approvals. history is the length of the conversation before
+// the message.
+type turn struct {
+	history       int
+	steps         []Step
+	usage         provider.Usage
+	finishReason  provider.FinishReason
+	continuations int
+	calls         []provider.ToolCall
+	approvals     map[string]Approval
+	requests      []ApprovalRequest
+}
+
+// run calls the model until it stops calling tools. A turn that still has
+// calls to execute starts with them instead. If the turn fails before those
+// calls are answered, the history is rolled back to where it was before the
+// message, since tool calls without results cannot be sent again.
+func (a *agent) run(ctx context.Context, writer io.Writer, t *turn, system []provider.Message, providerTools []provider.Tool) error {
+	// Options already in ctx come from the caller and win over the agent's.
+	opts := a.options.Merge(provider.GenerationOptions{
+		ToolChoice:        a.toolChoice,
+		ParallelToolCalls: a.parallelCalls,
+	})
+	opts = opts.Merge(provider.GenerationOptionsFromContext(ctx))
+	ctx = provider.ContextWithGenerationOptions(ctx, opts)
 
 	outputWriter := writer
 	if a.streamHandler != nil {
 		outputWriter = a.streamHandler
 	}
 
+	steps := stream.NewStepWriter(outputWriter)
+	emitter := stream.NewEmitter(outputWriter)
+	if len(t.calls) > 0 {
+		steps.Start()
+	}
+
 	for {
-		streamResult, err := a.provider.StreamChat(a.messages, providerTools, outputWriter)
-		if err != nil {
-			return fmt.Errorf("stream chat: %w", err)
+		if len(t.calls) == 0 {
+			streamResult, err := a.provider.StreamChatContext(ctx, append(system, a.messages...), providerTools, steps)
+			if err != nil {
+				return fmt.Errorf("stream chat: %w", err)
+			}
+			t.usage = t.usage.Add(streamResult.Usage)
+			t.finishReason = streamResult.FinishReason
+
+			assistantMsg := streamResult.Message
+			for i := range assistantMsg.ToolCalls {
+				if assistantMsg.ToolCalls[i].Type == "" {
+					assistantMsg.ToolCalls[i].Type = "function"
+				}
+			}
+			t.steps = append(t.steps, Step{
+				Number:       len(t.steps) + 1,
+				Message:      assistantMsg,
+				Usage:        streamResult.Usage,
+				FinishReason: t.finishReason,
+			})
+
+			switch {
+			case t.finishReason.Truncated():
+				if len(assistantMsg.ToolCalls) == 0 && t.continuations < a.continuations && !a.shouldStop(t.steps) {
+					t.continuations++
+					a.messages = append(a.messages, assistantMsg, provider.Message{
+						Role:    "user",
+						Content: continuePrompt,
+					})
+					continue
+				}
+				// Tool arguments may have been cut off too, so they are
+				// dropped rather than executed.
+				assistantMsg.ToolCalls = nil
+				if assistantMsg.Content != "" {
+					a.messages = append(a.messages, assistantMsg)
+				}
+				a.finish(emitter, t.finishReason, t.usage)
+				return fmt.Errorf("%w: %s", ErrOutputTruncated, t.finishReason)
+
+			case t.finishReason == provider.FinishReasonError:
+				a.finish(emitter, t.finishReason, t.usage)
+				return ErrGenerationFailed
+			}
+
+			if len(assistantMsg.ToolCalls) == 0 {
+				a.messages = append(a.messages, assistantMsg)
+				break
+			}
+
+			// The calls are dropped so the history never ends in tool
+			// results the model has not answered.
+			if a.shouldStop(t.steps) {
+				assistantMsg.ToolCalls = nil
+				if assistantMsg.Content != "" {
+					a.messages = append(a.messages, assistantMsg)
+				}
+				emitter.Finish(t.finishReason.StreamReason(), map[string]any{
+					"usage":   t.usage,
+					"stopped": true,
+				})
+				emitter.Done()
+				return &StopError{Steps: t.steps, Usage: t.usage}
+			}
+
+			a.messages = append(a.messages, assistantMsg)
+			t.calls = assistantMsg.ToolCalls
+			t.approvals = map[string]Approval{}
 		}
 
-		assistantMsg := streamResult.Message
-		for i := range assistantMsg.ToolCalls {
-			if assistantMsg.ToolCalls[i].Type == "" {
-				assistantMsg.ToolCalls[i].Type = "function"
+		if err := a.approve(ctx, t, emitter); err != nil {
+			if errors.Is(err, ErrApprovalPending) {
+				a.pending = t
+				a.finish(emitter, t.finishReason, t.usage)
+			} else {
+				a.messages = a.messages[:t.history]
 			}
+			return err
 		}
-		a.messages = append(a.messages, assistantMsg)
 
-		if len(assistantMsg.ToolCalls) == 0 {
-			break
+		results, err := a.runTools(ctx, t.calls, t.approvals, emitter)
+		if err != nil {
+			a.messages = a.messages[:t.history]
+			return err
 		}
+		a.messages = append(a.messages, results...)
+		t.calls = nil
+		t.approvals = nil
 
-		emitter := stream.NewEmitter(outputWriter)
-		for _, tc := range assistantMsg.ToolCalls {
-			result, err := a.executeTool(tc)
+		if opts.ToolChoice.Forced() {
+			opts.ToolChoice = provider.ToolChoiceOf(provider.ToolChoiceAuto)
+			ctx = provider.ContextWithGenerationOptions(ctx, opts)
+		}
+	}
 
-			var outputData any
-			if err != nil {
-				outputData = map[string]string{"error": err.Error()}
-				result = fmt.Sprintf("error: %v", err)
-			} else {
-				if err := json.Unmarshal([]byte(result), &outputData); err != nil {
-					outputData = map[string]string{"result": result}
-				}
-			}
+	a.finish(emitter, t.finishReason, t.usage)
 
-			emitter.ToolOutputAvailable(tc.ID, outputData)
+	return nil
+}
 
-			a.messages = append(a.messages, provider.Message{
-				Role:       "tool",
-				Content:    result,
-				ToolCallID: tc.ID,
-			})
+// approve asks the approver about every call of the step that needs it and
+// has not been decided yet. Calls without an approver are denied.
+func (a *agent) approve(ctx context.Context, t *turn, emitter *stream.Emitter) error {
+	for _, tc := range t.calls {
+		if _, ok := t.approvals[tc.ID]; ok || !a.needsApproval(tc.Function.Name) {
+			continue
+		}
+		if a.approver == nil {
+			t.approvals[tc.ID] = Approval{Reason: "no approver is configured"}
+			continue
 		}
+
+		req := ApprovalRequest{ID: newApprovalID(), ToolCall: tc}
+		emitter.ToolApprovalRequest(req.ID, tc.ID)
+
+		approval, err := a.approver.Approve(ctx, req)
+		if errors.Is(err, ErrApprovalPending) {
+			t.requests = append(t.requests, req)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("approve %s: %w", tc.Function.Name, err)
+		}
+		t.approvals[tc.ID] = approval
 	}
 
+	if len(t.requests) > 0 {
+		return ErrApprovalPending
+	}
 	return nil
 }
 
+func hasImage(message provider.Message) bool {
+	for _, p := range message.Parts {
+		if p.IsImage() {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *agent) shouldStop(steps []Step) bool {
+	if a.maxSteps > 0 && len(steps) >= a.maxSteps {
+		return true
+	}
+	for _, cond := range a.stopWhen {
+		if cond(steps) {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *agent) finish(emitter *stream.Emitter, finishReason provider.FinishReason, usage provider.Usage) {
+	emitter.Finish(finishReason.StreamReason(), map[string]any{"usage": usage})
+	emitter.Done()
+}
+
 func (a *agent) buildTools() []provider.Tool {
 	tools := make([]provider.Tool, len(a.tools))
 	for i, t := range a.tools {
@@ -123,10 +547,135 @@ func (a *agent) buildTools() []provider.Tool {
 	return tools
 }
 
-func (a *agent) executeTool(tc provider.ToolCall) (string, error) {
+// runTools executes the calls of one step and returns the tool messages in
+// call order.
+func (a *agent) runTools(ctx context.Context, calls []provider.ToolCall, approvals map[string]Approval, emitter *stream.Emitter) ([]provider.Message, error) {
+	results := make([]provider.Message, len(calls))
+
+	var approved []int
+	for i, tc := range calls {
+		if approval, ok := approvals[tc.ID]; ok && !approval.Approved {
+			emitter.ToolOutputDenied(tc.ID)
+			results[i] = provider.Message{Role: "tool", Content: denied(approval), ToolCallID: tc.ID}
+			continue
+		}
+		approved = append(approved, i)
+	}
+
+	if a.concurrency <= 1 || len(approved) <= 1 {
+		for _, i := range approved {
+			tc := calls[i]
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			result, output := a.callTool(ctx, tc)
+			emitter.ToolOutputAvailable(tc.ID, output)
+			results[i] = provider.Message{Role: "tool", Content: result, ToolCallID: tc.ID}
+		}
+		return results, nil
+	}
+
+	type toolResult struct {
+		index  int
+		result string
+		output any
+		err    error
+	}
+
+	// Regular calls share the read lock; serial-only calls take the write
+	// lock so nothing else runs alongside them.
+	var serial sync.RWMutex
+	slots := make(chan struct{}, a.concurrency)
+	done := make(chan toolResult)
+
+	for _, i := range approved {
+		tc := calls[i]
+		go func() {
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			if a.serialOnly(tc.Function.Name) {
+				serial.Lock()
+				defer serial.Unlock()
+			} else {
+				serial.RLock()
+				defer serial.RUnlock()
+			}
+
+			if err := ctx.Err(); err != nil {
+				done <- toolResult{index: i, err: err}
+				return
+			}
+			result, output := a.callTool(ctx, tc)
+			done <- toolResult{index: i, result: result, output: output}
+		}()
+	}
+
+	// Events are only written from this goroutine, in completion order.
+	var err error
+	for range approved {
+		r := <-done
+		if r.err != nil {
+			err = r.err
+			continue
+		}
+		tc := calls[r.index]
+		emitter.ToolOutputAvailable(tc.ID, r.output)
+		results[r.index] = provider.Message{Role: "tool", Content: r.result, ToolCallID: tc.ID}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// callTool runs one call and returns the content sent back to the model along
+// with the output shown to the client. Tool errors are reported to the model
+// rather than ending the turn.
+func (a *agent) callTool(ctx context.Context, tc provider.ToolCall) (string, any) {
+	result, err := a.executeTool(ctx, tc)
+	if err != nil {
+		return fmt.Sprintf("error: %v", err), map[string]string{"error": err.Error()}
+	}
+
+	var output any
+	if err := json.Unmarshal([]byte(result), &output); err != nil {
+		output = map[string]string{"result": result}
+	}
+	return result, output
+}
+
+func denied(approval Approval) string {
+	if approval.Reason == "" {
+		return "error: the tool call was denied"
+	}
+	return "error: the tool call was denied: " + approval.Reason
+}
+
+func (a *agent) needsApproval(name string) bool {
+	for _, t := range a.tools {
+		if t.GetName() == name {
+			n, ok := t.(tool.NeedsApproval)
+			return ok && n.NeedsApproval()
+		}
+	}
+	return false
+}
+
+func (a *agent) serialOnly(name string) bool {
+	for _, t := range a.tools {
+		if t.GetName() == name {
+			s, ok := t.(tool.SerialOnly)
+			return ok && s.SerialOnly()
+		}
+	}
+	return false
+}
+
+func (a *agent) executeTool(ctx context.Context, tc provider.ToolCall) (string, error) {
 	for _, t := range a.tools {
 		if t.GetName() == tc.Function.Name {
-			return t.Call(json.RawMessage(tc.Function.Arguments))
+			return t.CallContext(ctx, json.RawMessage(tc.Function.Arguments))
 		}
 	}
 	return "", fmt.Errorf("tool not found: %s", tc.Function.Name)
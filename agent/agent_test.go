@@ -0,0 +1,584 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alexisbouchez/palm/provider"
+	"github.com/alexisbouchez/palm/provider/providertest"
+	"github.com/alexisbouchez/palm/store"
+	"github.com/alexisbouchez/palm/tool"
+)
+
+type addInput struct {
+	A int `json:"a"`
+	B int `json:"b"`
+}
+
+func addTool() tool.Tool[addInput] {
+	return tool.New[addInput]().
+		WithName("add").
+		WithExecute(func(in addInput) (string, error) {
+			return fmt.Sprint(in.A + in.B), nil
+		})
+}
+
+func roles(messages []provider.Message) string {
+	var out []string
+	for _, m := range messages {
+		out = append(out, m.Role)
+	}
+	return strings.Join(out, ",")
+}
+
+// finishEvent returns the last finish event written to out.
+func finishEvent(t *testing.T, out string) map[string]any {
+	t.Helper()
+
+	var finish map[string]any
+	for _, frame := range strings.Split(out, "\n\n") {
+		data, ok := strings.CutPrefix(frame, "data: ")
+		if !ok || data == "[DONE]" {
+			continue
+		}
+		var event map[string]any
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			t.Fatalf("bad frame %q: %v", frame, err)
+		}
+		if event["type"] == "finish" {
+			finish = event
+		}
+	}
+	if finish == nil {
+		t.Fatalf("no finish event in:\n%s", out)
+	}
+	return finish
+}
+
+func TestToolLoop(t *testing.T) {
+	fake := providertest.NewFake(
+		providertest.ToolCall("add", addInput{A: 1, B: 2}),
+		providertest.Text("It is 3."),
+	)
+	a := New().WithProvider(fake).WithTool(addTool())
+
+	if err := a.Chat("what is 1+2?", io.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := roles(a.Messages()); got != "user,assistant,tool,assistant" {
+		t.Fatalf("roles = %s", got)
+	}
+	result := a.Messages()[2]
+	if result.Content != "3" || result.ToolCallID != "call_1" {
+		t.Errorf("tool result = %+v", result)
+	}
+	if got := roles(fake.LastRequest().Messages); got != "user,assistant,tool" {
+		t.Errorf("second request roles = %s", got)
+	}
+}
+
+func TestStopConditionDropsToolCalls(t *testing.T) {
+	var calls atomic.Int32
+	add := addTool().WithExecute(func(in addInput) (string, error) {
+		calls.Add(1)
+		return fmt.Sprint(in.A + in.B), nil
+	})
+
+	for name, a := range map[string]Agent{
+		"max steps":     New().WithMaxSteps(1),
+		"has tool call": New().WithStopWhen(HasToolCall("add")),
+	} {
+		t.Run(name, func(t *testing.T) {
+			fake := providertest.NewFake(
+				providertest.ToolCall("add", addInput{A: 1, B: 2}),
+				providertest.Text("Hello."),
+			)
+			a.WithProvider(fake).WithTool(add)
+
+			err := a.Chat("what is 1+2?", io.Discard)
+			var stopErr *StopError
+			if !errors.As(err, &stopErr) || !errors.Is(err, ErrStopped) {
+				t.Fatalf("err = %v, want a *StopError", err)
+			}
+			if len(stopErr.Steps) != 1 || len(stopErr.Steps[0].Message.ToolCalls) != 1 {
+				t.Errorf("steps = %+v, want the step with the dropped call", stopErr.Steps)
+			}
+			if calls.Load() != 0 {
+				t.Errorf("tool ran %d times after the stop", calls.Load())
+			}
+
+			// The next message must not follow unanswered tool calls.
+			if err := a.Chat("hi", io.Discard); err != nil {
+				t.Fatal(err)
+			}
+			if got := roles(fake.LastRequest().Messages); got != "user,user" {
+				t.Errorf("follow-up request roles = %s", got)
+			}
+		})
+	}
+}
+
+type echoInput struct {
+	N int `json:"n"`
+}
+
+func TestToolConcurrencyKeepsCallOrder(t *testing.T) {
+	var started atomic.Int32
+	all := make(chan struct{})
+	echo := tool.New[echoInput]().
+		WithName("echo").
+		WithExecute(func(in echoInput) (string, error) {
+			if started.Add(1) == 3 {
+				close(all)
+			}
+			select {
+			case <-all:
+			case <-time.After(time.Second):
+				return "", errors.New("calls did not run at the same time")
+			}
+			// Finish in reverse order.
+			time.Sleep(time.Duration(3-in.N) * 10 * time.Millisecond)
+			return fmt.Sprint(in.N), nil
+		})
+
+	fake := providertest.NewFake(
+		providertest.ToolCall("echo", echoInput{N: 0}).
+			WithToolCall("echo", echoInput{N: 1}).
+			WithToolCall("echo", echoInput{N: 2}),
+		providertest.Text("Done."),
+	)
+	a := New().WithProvider(fake).WithTool(echo).WithToolConcurrency(3)
+
+	if err := a.Chat("echo", io.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	results := a.Messages()[2:5]
+	for i, m := range results {
+		if m.Role != "tool" || m.Content != fmt.Sprint(i) || m.ToolCallID != fmt.Sprintf("call_%d", i+1) {
+			t.Errorf("result %d = %+v", i, m)
+		}
+	}
+}
+
+func TestResumeAfterApproval(t *testing.T) {
+	var calls atomic.Int32
+	add := addTool().WithNeedsApproval(true).WithExecute(func(in addInput) (string, error) {
+		calls.Add(1)
+		return fmt.Sprint(in.A + in.B), nil
+	})
+
+	fake := providertest.NewFake(
+		providertest.ToolCall("add", addInput{A: 1, B: 2}).
+			WithToolCall("add", addInput{A: 3, B: 4}),
+		providertest.Text("Done."),
+	)
+	a := New().WithProvider(fake).WithTool(add).WithApprover(DeferApproval)
+
+	var out strings.Builder
+	if err := a.Chat("add things", &out); !errors.Is(err, ErrApprovalPending) {
+		t.Fatalf("err = %v, want ErrApprovalPending", err)
+	}
+	if !strings.Contains(out.String(), `"type":"tool-approval-request"`) {
+		t.Errorf("no approval request in:\n%s", out.String())
+	}
+	if err := a.Chat("again", io.Discard); err == nil {
+		t.Error("a new message was accepted while approvals are pending")
+	}
+
+	pending := a.PendingApprovals()
+	if len(pending) != 2 {
+		t.Fatalf("pending = %+v, want 2 requests", pending)
+	}
+
+	// Only the first call gets a decision; the second is denied.
+	out.Reset()
+	err := a.Resume(context.Background(), map[string]Approval{pending[0].ID: {Approved: true}}, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("tool ran %d times, want 1", calls.Load())
+	}
+	if !strings.Contains(out.String(), `"type":"tool-output-denied"`) {
+		t.Errorf("no denial in:\n%s", out.String())
+	}
+
+	if got := roles(a.Messages()); got != "user,assistant,tool,tool,assistant" {
+		t.Fatalf("roles = %s", got)
+	}
+	if got := a.Messages()[2].Content; got != "3" {
+		t.Errorf("approved result = %q", got)
+	}
+	if got := a.Messages()[3].Content; got != "error: the tool call was denied: no decision was given" {
+		t.Errorf("denied result = %q", got)
+	}
+	if a.PendingApprovals() != nil {
+		t.Error("approvals still pending after Resume")
+	}
+}
+
+func TestGenerationOptionsPerCall(t *testing.T) {
+	fake := providertest.NewFake(providertest.Text("a"), providertest.Text("b"))
+	a := New().WithProvider(fake).WithGenerationOptions(provider.GenerationOptions{
+		Temperature: provider.Ptr(0.7),
+		MaxTokens:   provider.Ptr(100),
+	})
+
+	if err := a.Chat("hi", io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if opts := fake.LastRequest().Options; *opts.Temperature != 0.7 || *opts.MaxTokens != 100 {
+		t.Errorf("agent options not sent: %+v", opts)
+	}
+
+	ctx := provider.ContextWithGenerationOptions(context.Background(), provider.GenerationOptions{Temperature: provider.Ptr(0.0)})
+	if err := a.ChatContext(ctx, "again", io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if opts := fake.LastRequest().Options; *opts.Temperature != 0 || *opts.MaxTokens != 100 {
+		t.Errorf("per-call override not applied: temperature %v, max tokens %v", *opts.Temperature, *opts.MaxTokens)
+	}
+}
+
+func TestUsageAddsUpAcrossSteps(t *testing.T) {
+	fake := providertest.NewFake(
+		providertest.ToolCall("add", addInput{A: 1, B: 2}).WithUsage(provider.Usage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15}),
+		providertest.Text("3").WithUsage(provider.Usage{PromptTokens: 20, CompletionTokens: 1, TotalTokens: 21}),
+	)
+	a := New().WithProvider(fake).WithTool(addTool())
+
+	var out strings.Builder
+	if err := a.Chat("what is 1+2?", &out); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := strings.Count(out.String(), `"type":"finish"`); n != 1 {
+		t.Errorf("got %d finish events, want 1", n)
+	}
+	usage := finishEvent(t, out.String())["messageMetadata"].(map[string]any)["usage"]
+	want := map[string]any{"prompt_tokens": 30.0, "completion_tokens": 6.0, "total_tokens": 36.0}
+	if !reflect.DeepEqual(usage, want) {
+		t.Errorf("usage = %v, want %v", usage, want)
+	}
+}
+
+func TestTruncatedOutput(t *testing.T) {
+	fake := providertest.NewFake(providertest.Text("Once upon").WithFinishReason(provider.FinishReasonLength))
+	a := New().WithProvider(fake)
+
+	var out strings.Builder
+	if err := a.Chat("tell a story", &out); !errors.Is(err, ErrOutputTruncated) {
+		t.Fatalf("err = %v, want ErrOutputTruncated", err)
+	}
+	if reason := finishEvent(t, out.String())["finishReason"]; reason != "length" {
+		t.Errorf("finish reason = %v, want length", reason)
+	}
+	if got := roles(a.Messages()); got != "user,assistant" {
+		t.Errorf("roles = %s, want the partial reply kept", got)
+	}
+}
+
+func TestTruncatedOutputContinues(t *testing.T) {
+	fake := providertest.NewFake(
+		providertest.Text("Once upon").WithFinishReason(provider.FinishReasonLength),
+		providertest.Text(" a time."),
+	)
+	a := New().WithProvider(fake).WithMaxContinuations(1)
+
+	if err := a.Chat("tell a story", io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	last := fake.LastRequest().Messages
+	if got := last[len(last)-1]; got.Role != "user" || got.Content != continuePrompt {
+		t.Errorf("continuation request ends with %+v", got)
+	}
+
+	fake.Script(
+		providertest.Text("a").WithFinishReason(provider.FinishReasonModelLength),
+		providertest.Text("b").WithFinishReason(provider.FinishReasonModelLength),
+	)
+	if err := a.Chat("again", io.Discard); !errors.Is(err, ErrOutputTruncated) {
+		t.Errorf("err = %v, want ErrOutputTruncated after the continuation limit", err)
+	}
+}
+
+func TestGenerationError(t *testing.T) {
+	fake := providertest.NewFake(providertest.Text("").WithFinishReason(provider.FinishReasonError))
+
+	var out strings.Builder
+	if err := New().WithProvider(fake).Chat("hi", &out); !errors.Is(err, ErrGenerationFailed) {
+		t.Fatalf("err = %v, want ErrGenerationFailed", err)
+	}
+	if reason := finishEvent(t, out.String())["finishReason"]; reason != "error" {
+		t.Errorf("finish reason = %v, want error", reason)
+	}
+}
+
+// describedFake reports info as the model it serves.
+type describedFake struct {
+	*providertest.Fake
+	info provider.ModelInfo
+}
+
+func (f describedFake) ListModels(context.Context) ([]provider.ModelInfo, error) {
+	return []provider.ModelInfo{f.info}, nil
+}
+
+func (f describedFake) DescribeModel(context.Context, string) (*provider.ModelInfo, error) {
+	return &f.info, nil
+}
+
+func TestImagesNeedVision(t *testing.T) {
+	image := provider.Message{Role: "user", Parts: []provider.ContentPart{
+		provider.TextPart("what is this?"),
+		provider.DataPart("image/png", []byte{1}),
+	}}
+
+	fake := providertest.NewFake(providertest.Text("A cat."))
+	blind := describedFake{Fake: fake, info: provider.ModelInfo{ID: "text-only", Capabilities: provider.ModelCapabilities{Chat: true}}}
+	if err := New().WithProvider(blind).ChatMessage(context.Background(), image, io.Discard); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("err = %v, want ErrUnsupported", err)
+	}
+	if len(fake.Requests()) != 0 {
+		t.Error("image was sent to a model without vision")
+	}
+
+	vision := describedFake{Fake: fake, info: provider.ModelInfo{ID: "pixtral", Capabilities: provider.ModelCapabilities{Chat: true, Vision: true}}}
+	if err := New().WithProvider(vision).ChatMessage(context.Background(), image, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if parts := fake.LastRequest().Messages[0].Parts; !reflect.DeepEqual(parts, image.Parts) {
+		t.Errorf("sent parts = %+v", parts)
+	}
+}
+
+func TestForcedToolChoiceAppliesToFirstStep(t *testing.T) {
+	fake := providertest.NewFake(
+		providertest.ToolCall("add", addInput{A: 1, B: 2}),
+		providertest.Text("3"),
+	)
+	a := New().WithProvider(fake).WithTool(addTool()).
+		WithToolChoice(*provider.ForceTool("add")).
+		WithParallelToolCalls(false)
+
+	if err := a.Chat("add", io.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	requests := fake.Requests()
+	if choice := requests[0].Options.ToolChoice; choice == nil || choice.Mode != provider.ToolChoiceFunction || choice.Function != "add" {
+		t.Errorf("first step tool choice = %+v, want add forced", choice)
+	}
+	if choice := requests[1].Options.ToolChoice; choice == nil || choice.Mode != provider.ToolChoiceAuto {
+		t.Errorf("second step tool choice = %+v, want auto", choice)
+	}
+	for i, r := range requests {
+		if p := r.Options.ParallelToolCalls; p == nil || *p {
+			t.Errorf("request %d parallel tool calls = %v, want false", i, p)
+		}
+	}
+}
+
+func TestToolChoicePerCall(t *testing.T) {
+	fake := providertest.NewFake(providertest.Text("Summary."))
+	a := New().WithProvider(fake).WithTool(addTool())
+
+	ctx := provider.ContextWithGenerationOptions(context.Background(), provider.GenerationOptions{ToolChoice: provider.ToolChoiceOf(provider.ToolChoiceNone)})
+	if err := a.ChatContext(ctx, "summarize", io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if choice := fake.LastRequest().Options.ToolChoice; choice == nil || choice.Mode != provider.ToolChoiceNone {
+		t.Errorf("tool choice = %+v, want none", choice)
+	}
+}
+
+func TestInstructionsSentFirstNotStored(t *testing.T) {
+	fake := providertest.NewFake(
+		providertest.ToolCall("add", addInput{A: 1, B: 2}),
+		providertest.Text("It is 3."),
+		providertest.Text("Bye."),
+	)
+	a := New().WithProvider(fake).WithTool(addTool()).WithInstructions("Answer in one sentence.")
+
+	if err := a.Chat("what is 1+2?", io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Chat("thanks", io.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, req := range fake.Requests() {
+		first := req.Messages[0]
+		if first.Role != "system" || first.Content != "Answer in one sentence." {
+			t.Errorf("request %d starts with %+v, want the instructions", i, first)
+		}
+		if n := strings.Count(roles(req.Messages), "system"); n != 1 {
+			t.Errorf("request %d has %d system messages, want 1", i, n)
+		}
+	}
+	if got := roles(a.Messages()); got != "user,assistant,tool,assistant,user,assistant" {
+		t.Errorf("history roles = %s, want no system message", got)
+	}
+}
+
+type userKey struct{}
+
+func TestInstructionsFunc(t *testing.T) {
+	fake := providertest.NewFake(providertest.Text("Hi Ada."), providertest.Text("Hi Bob."))
+
+	var toolNames []string
+	a := New().WithProvider(fake).WithTool(addTool()).WithInstructionsFunc(func(ctx context.Context, tools []provider.Tool) (string, error) {
+		toolNames = toolNames[:0]
+		for _, tl := range tools {
+			toolNames = append(toolNames, tl.Function.Name)
+		}
+		return fmt.Sprintf("The user is %s.", ctx.Value(userKey{})), nil
+	})
+
+	for _, user := range []string{"Ada", "Bob"} {
+		ctx := context.WithValue(context.Background(), userKey{}, user)
+		if err := a.ChatContext(ctx, "hello", io.Discard); err != nil {
+			t.Fatal(err)
+		}
+		if got := fake.LastRequest().Messages[0].Content; got != "The user is "+user+"." {
+			t.Errorf("instructions = %q, want them rendered for %s", got, user)
+		}
+	}
+	if !reflect.DeepEqual(toolNames, []string{"add"}) {
+		t.Errorf("tools passed to the func = %v, want [add]", toolNames)
+	}
+}
+
+func TestInstructionsFuncError(t *testing.T) {
+	fake := providertest.NewFake(providertest.Text("Hello."))
+	a := New().WithProvider(fake).WithInstructionsFunc(func(context.Context, []provider.Tool) (string, error) {
+		return "", errors.New("template broke")
+	})
+
+	if err := a.Chat("hello", io.Discard); err == nil || !strings.Contains(err.Error(), "template broke") {
+		t.Errorf("err = %v, want the render error", err)
+	}
+	if len(fake.Requests()) != 0 {
+		t.Error("provider called without instructions")
+	}
+}
+
+func TestEmptyInstructionsSendNoSystemMessage(t *testing.T) {
+	fake := providertest.NewFake(providertest.Text("Hello."))
+	a := New().WithProvider(fake).WithInstructions("")
+
+	if err := a.Chat("hello", io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if got := roles(fake.LastRequest().Messages); got != "user" {
+		t.Errorf("request roles = %s, want user only", got)
+	}
+}
+
+func toolCalls(n int) []providertest.Turn {
+	turns := make([]providertest.Turn, n)
+	for i := range turns {
+		turns[i] = providertest.ToolCall("add", addInput{A: i, B: 1})
+	}
+	return turns
+}
+
+func TestDefaultMaxSteps(t *testing.T) {
+	fake := providertest.NewFake(toolCalls(defaultMaxSteps + 5)...)
+	a := New().WithProvider(fake).WithTool(addTool())
+
+	var out strings.Builder
+	err := a.Chat("count forever", &out)
+	var stopErr *StopError
+	if !errors.As(err, &stopErr) {
+		t.Fatalf("err = %v, want a *StopError", err)
+	}
+	if len(stopErr.Steps) != defaultMaxSteps || len(fake.Requests()) != defaultMaxSteps {
+		t.Errorf("made %d steps and %d requests, want %d", len(stopErr.Steps), len(fake.Requests()), defaultMaxSteps)
+	}
+	if reason := finishEvent(t, out.String())["finishReason"]; reason != "tool-calls" {
+		t.Errorf("finish reason = %v, want tool-calls", reason)
+	}
+}
+
+func TestUnlimitedSteps(t *testing.T) {
+	turns := append(toolCalls(defaultMaxSteps+5), providertest.Text("Done."))
+	fake := providertest.NewFake(turns...)
+	a := New().WithProvider(fake).WithTool(addTool()).WithMaxSteps(0)
+
+	if err := a.Chat("count for a while", io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(fake.Requests()); n != len(turns) {
+		t.Errorf("made %d requests, want %d", n, len(turns))
+	}
+}
+
+// A turn that fails while tool calls are unanswered leaves both the saved and
+// the in-memory history as they were before the message.
+func TestFailedToolTurnRollsBack(t *testing.T) {
+	tests := map[string]func(cancel context.CancelFunc) Agent{
+		"cancel during tools": func(cancel context.CancelFunc) Agent {
+			add := addTool().WithExecute(func(in addInput) (string, error) {
+				cancel()
+				return fmt.Sprint(in.A + in.B), nil
+			})
+			return New().WithTool(add)
+		},
+		"approver error": func(context.CancelFunc) Agent {
+			return New().WithTool(addTool().WithNeedsApproval(true)).
+				WithApprover(ApproverFunc(func(context.Context, ApprovalRequest) (Approval, error) {
+					return Approval{}, errors.New("approval service down")
+				}))
+		},
+	}
+
+	for name, newAgent := range tests {
+		t.Run(name, func(t *testing.T) {
+			fake := providertest.NewFake(
+				providertest.Text("Hello."),
+				providertest.ToolCall("add", addInput{A: 1, B: 2}).WithToolCall("add", addInput{A: 3, B: 4}),
+				providertest.Text("Hi again."),
+			)
+			conversations := store.NewMemory()
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			a := newAgent(cancel).WithProvider(fake).WithConversationStore(conversations, "c1")
+
+			if err := a.ChatContext(ctx, "hi", io.Discard); err != nil {
+				t.Fatal(err)
+			}
+			if err := a.ChatContext(ctx, "add things", io.Discard); err == nil {
+				t.Fatal("want the turn to fail")
+			}
+
+			saved, err := conversations.Load(context.Background(), "c1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := roles(saved); got != "user,assistant" {
+				t.Errorf("saved roles = %s, want the history before the failed turn", got)
+			}
+			if got := roles(a.Messages()); got != "user,assistant" {
+				t.Errorf("in-memory roles = %s, want the history before the failed turn", got)
+			}
+
+			if err := a.ChatContext(context.Background(), "hello?", io.Discard); err != nil {
+				t.Fatal(err)
+			}
+			if got := roles(fake.LastRequest().Messages); got != "user,assistant,user" {
+				t.Errorf("next request roles = %s", got)
+			}
+		})
+	}
+}
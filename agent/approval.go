@@ -0,0 +1,88 @@
+package agent
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/alexisbouchez/palm/provider"
+)
+
+// ApprovalRequest asks whether a tool call may run. ID is sent to the client
+// in the tool-approval-request event and names the decision given to Resume.
+type ApprovalRequest struct {
+	ID       string
+	ToolCall provider.ToolCall
+}
+
+type Approval struct {
+	Approved bool
+	Reason   string
+}
+
+// Approver decides on calls to tools that implement tool.NeedsApproval.
+// Returning ErrApprovalPending defers the decision: the turn ends and
+// continues once Resume is called with the answer.
+type Approver interface {
+	Approve(ctx context.Context, req ApprovalRequest) (Approval, error)
+}
+
+type ApproverFunc func(ctx context.Context, req ApprovalRequest) (Approval, error)
+
+func (f ApproverFunc) Approve(ctx context.Context, req ApprovalRequest) (Approval, error) {
+	return f(ctx, req)
+}
+
+var ErrApprovalPending = errors.New("tool approval pending")
+
+// DeferApproval leaves every decision to the client, for servers that end the
+// stream after the tool-approval-request events and resume on a new request.
+var DeferApproval Approver = ApproverFunc(func(context.Context, ApprovalRequest) (Approval, error) {
+	return Approval{}, ErrApprovalPending
+})
+
+type consoleApprover struct {
+	reader *bufio.Reader
+	writer io.Writer
+}
+
+// NewConsoleApprover prompts on w and reads a y/n answer from r. Pass the
+// reader the REPL already uses so no buffered input is lost.
+func NewConsoleApprover(r io.Reader, w io.Writer) Approver {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	return &consoleApprover{reader: br, writer: w}
+}
+
+func (c *consoleApprover) Approve(ctx context.Context, req ApprovalRequest) (Approval, error) {
+	call := req.ToolCall.Function
+	icon := toolStyle.Render("?")
+	label := dimStyle.Render(" Allow")
+	name := toolStyle.Render(" " + call.Name)
+	fmt.Fprintf(c.writer, "%s%s%s%s ", icon, label, name, dimStyle.Render("("+call.Arguments+")? [y/N]"))
+
+	answer, err := c.reader.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return Approval{}, fmt.Errorf("read answer: %w", err)
+	}
+
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return Approval{Approved: true}, nil
+	default:
+		return Approval{Reason: "denied by the user"}, nil
+	}
+}
+
+func newApprovalID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return "approval_" + hex.EncodeToString(b)
+}
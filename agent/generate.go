@@ -0,0 +1,82 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/alexisbouchez/palm/provider"
+	"github.com/alexisbouchez/palm/tool"
+)
+
+var ErrInvalidOutput = errors.New("invalid structured output")
+
+const maxGenerateAttempts = 3
+
+var schemaNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// Generate sends prompt to the agent with a JSON schema response format built
+// from T and decodes the reply into T. Replies that do not match the schema
+// are sent back to the model with the validation error, up to a few times.
+func Generate[T any](ctx context.Context, a Agent, prompt string) (T, error) {
+	var result T
+
+	schema := tool.Schema[T]()
+	name := reflect.TypeFor[T]().Name()
+	if !schemaNamePattern.MatchString(name) {
+		name = "response"
+	}
+
+	ctx = provider.ContextWithGenerationOptions(ctx, provider.GenerationOptions{
+		ResponseFormat: &provider.ResponseFormat{
+			Type:   provider.ResponseFormatJSONSchema,
+			Name:   name,
+			Schema: schema,
+		},
+	})
+
+	var lastErr error
+	for attempt := 0; attempt < maxGenerateAttempts; attempt++ {
+		if attempt > 0 {
+			prompt = fmt.Sprintf("Your reply did not match the schema: %v. Reply again with only the corrected JSON.", lastErr)
+		}
+		if err := a.ChatContext(ctx, prompt, io.Discard); err != nil {
+			return result, err
+		}
+
+		reply := lastReply(a.Messages())
+		if err := tool.Validate(schema, json.RawMessage(reply)); err != nil {
+			lastErr = err
+			continue
+		}
+		if err := json.Unmarshal([]byte(reply), &result); err != nil {
+			lastErr = err
+			continue
+		}
+		return result, nil
+	}
+
+	return result, fmt.Errorf("%w: %v", ErrInvalidOutput, lastErr)
+}
+
+// lastReply returns the text of the final assistant message, without the
+// markdown code fence some models wrap JSON in.
+func lastReply(messages []provider.Message) string {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role != "assistant" {
+			continue
+		}
+		text := strings.TrimSpace(messages[i].Text())
+		if body, ok := strings.CutPrefix(text, "```"); ok {
+			body = strings.TrimPrefix(body, "json")
+			text = strings.TrimSpace(strings.TrimSuffix(body, "```"))
+		}
+		return text
+	}
+	return ""
+}
@@ -0,0 +1,85 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/alexisbouchez/palm/provider"
+	"github.com/alexisbouchez/palm/provider/providertest"
+)
+
+type ticket struct {
+	Title    string `json:"title" required:"true"`
+	Priority int    `json:"priority"`
+}
+
+func TestGenerate(t *testing.T) {
+	fake := providertest.NewFake(providertest.Text("```json\n{\"title\":\"Login fails\",\"priority\":2}\n```"))
+
+	got, err := Generate[ticket](context.Background(), New().WithProvider(fake), "extract the ticket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != (ticket{Title: "Login fails", Priority: 2}) {
+		t.Errorf("ticket = %+v", got)
+	}
+
+	format := fake.LastRequest().Options.ResponseFormat
+	if format == nil || format.Type != provider.ResponseFormatJSONSchema || format.Name != "ticket" || !strings.Contains(string(format.Schema), `"required":["title"]`) {
+		t.Errorf("response format = %+v", format)
+	}
+}
+
+func TestGenerateRetriesWithValidationError(t *testing.T) {
+	fake := providertest.NewFake(
+		providertest.Text(`{"priority":"high"}`),
+		providertest.Text(`{"title":"Login fails","priority":1}`),
+	)
+
+	got, err := Generate[ticket](context.Background(), New().WithProvider(fake), "extract the ticket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Title != "Login fails" {
+		t.Errorf("ticket = %+v", got)
+	}
+
+	retry := fake.LastRequest().Messages
+	if last := retry[len(retry)-1]; last.Role != "user" || !strings.Contains(last.Content, `missing required field "title"`) {
+		t.Errorf("retry prompt = %q, want the validation error", last.Content)
+	}
+}
+
+func TestGenerateGivesUp(t *testing.T) {
+	fake := providertest.NewFake(
+		providertest.Text("not json"),
+		providertest.Text(`{"title":3}`),
+		providertest.Text(`{}`),
+		providertest.Text(`{"title":"too late"}`),
+	)
+
+	_, err := Generate[ticket](context.Background(), New().WithProvider(fake), "extract the ticket")
+	if !errors.Is(err, ErrInvalidOutput) {
+		t.Fatalf("err = %v, want ErrInvalidOutput", err)
+	}
+	if n := len(fake.Requests()); n != maxGenerateAttempts {
+		t.Errorf("asked %d times, want %d", n, maxGenerateAttempts)
+	}
+}
+
+func TestGenerateSchemaName(t *testing.T) {
+	fake := providertest.NewFake(providertest.Text(`["a","b"]`))
+
+	got, err := Generate[[]string](context.Background(), New().WithProvider(fake), "list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 {
+		t.Errorf("got %v", got)
+	}
+	if name := fake.LastRequest().Options.ResponseFormat.Name; name != "response" {
+		t.Errorf("schema name = %q, want the fallback for unnamed types", name)
+	}
+}
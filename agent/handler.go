@@ -72,12 +72,30 @@ type ConsoleHandler struct {
 	buffer      strings.Builder
 	program     *tea.Program
 	isStreaming bool
+
+	showReasoning  bool
+	reasoning      strings.Builder
+	reasoningStart time.Time
+	lastReasoning  string
 }
 
 func NewConsoleHandler(w io.Writer) *ConsoleHandler {
 	return &ConsoleHandler{writer: w}
 }
 
+// ToggleReasoning switches between printing the model's reasoning as it
+// streams and collapsing it into a one-line summary. It reports whether
+// reasoning is now shown.
+func (h *ConsoleHandler) ToggleReasoning() bool {
+	h.showReasoning = !h.showReasoning
+	return h.showReasoning
+}
+
+// LastReasoning returns the reasoning of the most recent reply.
+func (h *ConsoleHandler) LastReasoning() string {
+	return h.lastReasoning
+}
+
 func (h *ConsoleHandler) startSpinner(message string) {
 	if h.program != nil {
 		return
@@ -145,6 +163,34 @@ func (h *ConsoleHandler) handleEvent(event map[string]any) {
 	case "start":
 		h.startSpinner("Thinking...")
 
+	case "reasoning-start":
+		h.reasoning.Reset()
+		h.reasoningStart = time.Now()
+		if h.showReasoning {
+			h.stopSpinner()
+			fmt.Fprintln(h.writer, dimStyle.Render("▾ Reasoning"))
+		} else {
+			h.startSpinner("Reasoning...")
+		}
+
+	case "reasoning-delta":
+		if delta, ok := event["delta"].(string); ok {
+			h.reasoning.WriteString(delta)
+			if h.showReasoning {
+				fmt.Fprint(h.writer, dimStyle.Render(delta))
+			}
+		}
+
+	case "reasoning-end":
+		h.lastReasoning = h.reasoning.String()
+		if h.showReasoning {
+			fmt.Fprint(h.writer, "\n\n")
+		} else {
+			h.stopSpinner()
+			summary := fmt.Sprintf("▸ Reasoned for %s (/reasoning to expand)", time.Since(h.reasoningStart).Round(time.Second))
+			fmt.Fprintf(h.writer, "%s\n\n", dimStyle.Render(summary))
+		}
+
 	case "text-start":
 		h.stopSpinner()
 		h.isStreaming = true
@@ -183,12 +229,48 @@ func (h *ConsoleHandler) handleEvent(event map[string]any) {
 		}
 		h.startSpinner("Thinking...")
 
+	case "tool-approval-request":
+		h.stopSpinner()
+
+	case "tool-output-denied":
+		h.stopSpinner()
+		icon := errorStyle.Render("✗")
+		label := dimStyle.Render(" Denied")
+		fmt.Fprintf(h.writer, "%s%s\n\n", icon, label)
+		h.startSpinner("Thinking...")
+
 	case "finish":
 		h.stopSpinner()
 		if !h.isStreaming {
 			fmt.Fprintln(h.writer)
 		}
+		if reason, _ := event["finishReason"].(string); reason == "length" {
+			fmt.Fprintf(h.writer, "%s\n", errorStyle.Render("Response truncated: the token limit was reached."))
+		}
+		if metadata, ok := event["messageMetadata"].(map[string]any); ok {
+			if stopped, _ := metadata["stopped"].(bool); stopped {
+				fmt.Fprintf(h.writer, "%s\n", errorStyle.Render("Stopped early: a step or token limit was reached."))
+			}
+			h.printUsage(metadata["usage"])
+		}
+	}
+}
+
+func (h *ConsoleHandler) printUsage(usage any) {
+	u, ok := usage.(map[string]any)
+	if !ok {
+		return
 	}
+
+	total, _ := u["total_tokens"].(float64)
+	if total == 0 {
+		return
+	}
+	prompt, _ := u["prompt_tokens"].(float64)
+	completion, _ := u["completion_tokens"].(float64)
+
+	footer := fmt.Sprintf("%.0f tokens (%.0f prompt, %.0f completion)", total, prompt, completion)
+	fmt.Fprintf(h.writer, "%s\n\n", dimStyle.Render(footer))
 }
 
 func (h *ConsoleHandler) Flush() {
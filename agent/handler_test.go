@@ -0,0 +1,30 @@
+package agent
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestConsoleHandlerUsageFooter(t *testing.T) {
+	var out bytes.Buffer
+	h := NewConsoleHandler(&out)
+
+	h.Write([]byte(`data: {"type":"text-start","id":"t"}` + "\n\n"))
+	h.Write([]byte(`data: {"type":"text-delta","id":"t","delta":"Hello"}` + "\n\n"))
+	h.Write([]byte(`data: {"type":"text-end","id":"t"}` + "\n\n"))
+	h.Write([]byte(`data: {"type":"finish","finishReason":"stop","messageMetadata":{"usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15}}}` + "\n\n"))
+
+	if !strings.Contains(out.String(), "15 tokens (10 prompt, 5 completion)") {
+		t.Errorf("no usage footer in %q", out.String())
+	}
+}
+
+func TestConsoleHandlerSkipsEmptyUsage(t *testing.T) {
+	var out bytes.Buffer
+	NewConsoleHandler(&out).Write([]byte(`data: {"type":"finish","messageMetadata":{"usage":{"total_tokens":0}}}` + "\n\n"))
+
+	if strings.Contains(out.String(), "tokens") {
+		t.Errorf("printed a footer without usage: %q", out.String())
+	}
+}
@@ -0,0 +1,74 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/alexisbouchez/palm/provider"
+)
+
+// Step is one model call made while answering a message.
+type Step struct {
+	Number       int
+	Message      provider.Message
+	Usage        provider.Usage
+	FinishReason provider.FinishReason
+}
+
+// StopCondition is checked after each model call that asks for tools, with
+// every step of the current turn so far. Returning true ends the turn without
+// running those tools.
+type StopCondition func(steps []Step) bool
+
+// StepCountIs stops once n model calls have been made.
+func StepCountIs(n int) StopCondition {
+	return func(steps []Step) bool {
+		return len(steps) >= n
+	}
+}
+
+// HasToolCall stops after a step in which the model called the named tool.
+// The call is not executed; its arguments are in the last step of the
+// StopError.
+func HasToolCall(name string) StopCondition {
+	return func(steps []Step) bool {
+		if len(steps) == 0 {
+			return false
+		}
+		for _, tc := range steps[len(steps)-1].Message.ToolCalls {
+			if tc.Function.Name == name {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// TokenBudget stops once the turn has used at least tokens tokens in total.
+func TokenBudget(tokens int) StopCondition {
+	return func(steps []Step) bool {
+		var usage provider.Usage
+		for _, s := range steps {
+			usage = usage.Add(s.Usage)
+		}
+		return usage.TotalTokens >= tokens
+	}
+}
+
+var ErrStopped = errors.New("stopped before the model finished")
+
+// StopError is returned when a stop condition ended the turn while the model
+// still wanted to call tools. The stream has already been closed with a
+// finish event.
+type StopError struct {
+	Steps []Step
+	Usage provider.Usage
+}
+
+func (e *StopError) Error() string {
+	return fmt.Sprintf("%s: after %d steps", ErrStopped, len(e.Steps))
+}
+
+func (e *StopError) Unwrap() error {
+	return ErrStopped
+}
@@ -7,8 +7,10 @@ import (
 	"strings"
 
 	"github.com/alexisbouchez/palm/env"
+	"github.com/alexisbouchez/palm/provider"
 	"github.com/alexisbouchez/palm/provider/mistral"
 	"github.com/alexisbouchez/palm/server"
+	"github.com/alexisbouchez/palm/store"
 	"github.com/alexisbouchez/palm/tool"
 )
 
@@ -19,8 +21,10 @@ type WeatherInput struct {
 func main() {
 	addr := env.GetVar("HTTP_ADDR", ":4096")
 
-	provider := mistral.New().
-		WithAPIKey(os.Getenv("MISTRAL_API_KEY"))
+	llm := provider.Chain(
+		mistral.New().WithAPIKey(os.Getenv("MISTRAL_API_KEY")),
+		provider.Logging(slog.Default()),
+	)
 
 	weatherTool := tool.New[WeatherInput]().
 		WithName("get_weather").
@@ -30,7 +34,16 @@ func main() {
 			return "The weather in " + input.Location + " is sunny, 22°C", nil
 		})
 
-	srv := server.New(provider, []tool.Callable{weatherTool})
+	conversations, err := openStore()
+	if err != nil {
+		slog.Error("failed to open conversation store", "error", err)
+		os.Exit(1)
+	}
+	defer conversations.Close()
+
+	srv := server.New(llm, []tool.Callable{weatherTool}).
+		WithInstructions(env.GetVar("PALM_INSTRUCTIONS", "")).
+		WithConversationStore(conversations)
 
 	if err := srv.Start(addr); err != nil {
 		if strings.Contains(err.Error(), "address already in use") {
@@ -49,3 +62,19 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// openStore picks the conversation store from PALM_STORE: memory (the
+// default), json for one file per conversation or bolt for a database file,
+// both at PALM_STORE_PATH.
+func openStore() (store.ConversationStore, error) {
+	switch kind := env.GetVar("PALM_STORE", "memory"); kind {
+	case "memory":
+		return store.NewMemory(), nil
+	case "json":
+		return store.NewDir(env.GetVar("PALM_STORE_PATH", "conversations"))
+	case "bolt":
+		return store.NewBolt(env.GetVar("PALM_STORE_PATH", "palm.db"))
+	default:
+		return nil, fmt.Errorf("unknown store %q", kind)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/alexisbouchez/palm/provider"
+	"github.com/alexisbouchez/palm/provider/mistral"
+)
+
+// runComplete implements `palm complete`, which prints the code that goes
+// between the contents of the prefix and suffix files.
+func runComplete(args []string) error {
+	fs := flag.NewFlagSet("complete", flag.ExitOnError)
+	prefixPath := fs.String("prefix", "", "file holding the text before the cursor (- for stdin)")
+	suffixPath := fs.String("suffix", "", "file holding the text after the cursor")
+	model := fs.String("model", "codestral-latest", "completion model")
+	maxTokens := fs.Int("max-tokens", 0, "maximum number of tokens to generate")
+	streamOutput := fs.Bool("stream", false, "print the completion as it is generated")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: palm complete -prefix FILE [-suffix FILE] [flags]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *prefixPath == "" {
+		fs.Usage()
+		return errors.New("missing -prefix")
+	}
+
+	prefix, err := readInput(*prefixPath)
+	if err != nil {
+		return fmt.Errorf("read prefix: %w", err)
+	}
+
+	var suffix string
+	if *suffixPath != "" {
+		if suffix, err = readInput(*suffixPath); err != nil {
+			return fmt.Errorf("read suffix: %w", err)
+		}
+	}
+
+	completer := mistral.NewCompleter().
+		WithAPIKey(os.Getenv("MISTRAL_API_KEY")).
+		WithModel(*model)
+	if *maxTokens > 0 {
+		completer.WithGenerationOptions(provider.GenerationOptions{MaxTokens: maxTokens})
+	}
+
+	ctx := context.Background()
+	if *streamOutput {
+		_, err = completer.StreamComplete(ctx, prefix, suffix, os.Stdout)
+		return err
+	}
+
+	completion, err := completer.Complete(ctx, prefix, suffix)
+	if err != nil {
+		return err
+	}
+	fmt.Print(completion.Text)
+	return nil
+}
+
+func readInput(path string) (string, error) {
+	if path == "-" {
+		b, err := io.ReadAll(os.Stdin)
+		return string(b), err
+	}
+	b, err := os.ReadFile(path)
+	return string(b), err
+}
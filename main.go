@@ -2,6 +2,9 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log/slog"
@@ -9,6 +12,7 @@ import (
 	"strings"
 
 	"github.com/alexisbouchez/palm/agent"
+	"github.com/alexisbouchez/palm/env"
 	"github.com/alexisbouchez/palm/provider/mistral"
 	"github.com/alexisbouchez/palm/tool"
 	"github.com/charmbracelet/lipgloss"
@@ -22,6 +26,36 @@ type WeatherInput struct {
 func main() {
 	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
 
+	if len(os.Args) > 1 {
+		var run func([]string) error
+		switch os.Args[1] {
+		case "complete":
+			run = runComplete
+		case "models":
+			run = runModels
+		}
+		if run != nil {
+			if err := run(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	instructions := flag.String("instructions", env.GetVar("PALM_INSTRUCTIONS", ""), "system prompt for the agent")
+	instructionsFile := flag.String("instructions-file", "", "file holding the system prompt")
+	flag.Parse()
+
+	if *instructionsFile != "" {
+		b, err := os.ReadFile(*instructionsFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading instructions: %v\n", err)
+			os.Exit(1)
+		}
+		*instructions = string(b)
+	}
+
 	provider := mistral.New().
 		WithAPIKey(os.Getenv("MISTRAL_API_KEY"))
 
@@ -38,10 +72,17 @@ func main() {
 	agt := agent.New().
 		WithProvider(provider).
 		WithTool(weatherTool).
-		WithStreamHandler(consoleHandler)
+		WithStreamHandler(consoleHandler).
+		WithInstructions(*instructions)
+
+	if err := agt.CheckCapabilities(context.Background()); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
 
 	if term.IsTerminal(int(os.Stdin.Fd())) {
 		reader := bufio.NewReader(os.Stdin)
+		agt.WithApprover(agent.NewConsoleApprover(reader, os.Stdout))
 		promptStyle := lipgloss.NewStyle().
 			Foreground(lipgloss.Color("12")).
 			Bold(true)
@@ -64,8 +105,18 @@ func main() {
 			if input == "exit" || input == "quit" {
 				break
 			}
+			if input == "/reasoning" {
+				if consoleHandler.ToggleReasoning() && consoleHandler.LastReasoning() != "" {
+					fmt.Println(lipgloss.NewStyle().Foreground(lipgloss.Color("8")).Render(consoleHandler.LastReasoning()))
+				}
+				continue
+			}
 
 			if err := agt.Chat(input, os.Stdout); err != nil {
+				// The console handler already told the user the reply was cut off or stopped.
+				if errors.Is(err, agent.ErrOutputTruncated) || errors.Is(err, agent.ErrStopped) {
+					continue
+				}
 				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 				os.Exit(1)
 			}
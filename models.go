@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/alexisbouchez/palm/provider"
+	"github.com/alexisbouchez/palm/provider/mistral"
+)
+
+// runModels implements `palm models`, which lists the available models and
+// their capabilities, or describes the models named as arguments.
+func runModels(args []string) error {
+	fs := flag.NewFlagSet("models", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: palm models [MODEL...]")
+	}
+	fs.Parse(args)
+
+	lister := mistral.New().
+		WithAPIKey(os.Getenv("MISTRAL_API_KEY")).(provider.ModelLister)
+
+	ctx := context.Background()
+	var models []provider.ModelInfo
+	if fs.NArg() == 0 {
+		var err error
+		if models, err = lister.ListModels(ctx); err != nil {
+			return err
+		}
+	}
+	for _, id := range fs.Args() {
+		info, err := lister.DescribeModel(ctx, id)
+		if err != nil {
+			return err
+		}
+		models = append(models, *info)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "MODEL\tCONTEXT\tCAPABILITIES")
+	for _, m := range models {
+		fmt.Fprintf(w, "%s\t%d\t%s\n", m.ID, m.ContextLength, capabilityList(m.Capabilities))
+	}
+	return w.Flush()
+}
+
+func capabilityList(c provider.ModelCapabilities) string {
+	var caps []string
+	if c.Chat {
+		caps = append(caps, "chat")
+	}
+	if c.FunctionCalling {
+		caps = append(caps, "tools")
+	}
+	if c.Vision {
+		caps = append(caps, "vision")
+	}
+	if c.FIM {
+		caps = append(caps, "fim")
+	}
+	if c.Embedding {
+		caps = append(caps, "embedding")
+	}
+	return strings.Join(caps, ", ")
+}
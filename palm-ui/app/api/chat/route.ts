@@ -3,7 +3,7 @@ export const dynamic = "force-dynamic";
 
 export async function POST(req: Request) {
   const body = await req.json();
-  const { messages } = body;
+  const { id, messages } = body;
 
   if (!messages || messages.length === 0) {
     return new Response("No messages provided", { status: 400 });
@@ -11,12 +11,31 @@ export async function POST(req: Request) {
 
   const lastMessage = messages[messages.length - 1];
 
+  const approvals = lastMessage?.role === "assistant"
+    ? (lastMessage.parts || [])
+        .filter((part: any) => part.state === "approval-responded" && part.approval)
+        .map((part: any) => ({
+          id: part.approval.id,
+          approved: part.approval.approved,
+          reason: part.approval.reason,
+        }))
+    : [];
+
   const userMessage = lastMessage?.parts
     ?.filter((part: any) => part.type === "text")
     .map((part: any) => part.text)
     .join("") || lastMessage?.content || "";
 
-  if (!userMessage) {
+  const files = (lastMessage?.parts || [])
+    .filter((part: any) => part.type === "file")
+    .map((part: any) => ({
+      type: "file",
+      mediaType: part.mediaType,
+      filename: part.filename,
+      url: part.url,
+    }));
+
+  if (!userMessage && files.length === 0 && approvals.length === 0) {
     return new Response("No message provided", { status: 400 });
   }
 
@@ -30,9 +49,15 @@ export async function POST(req: Request) {
       headers: {
         "Content-Type": "application/json",
       },
-      body: JSON.stringify({
-        message: userMessage,
-      }),
+      body: JSON.stringify(
+        approvals.length > 0
+          ? { id, approvals }
+          : {
+              id,
+              message: userMessage,
+              parts: files.length > 0 ? files : undefined,
+            }
+      ),
     });
 
     console.log("Palm server response status:", response.status);
@@ -2,6 +2,7 @@
 
 import * as React from "react";
 import { useChat } from "@ai-sdk/react";
+import { lastAssistantMessageIsCompleteWithApprovalResponses } from "ai";
 import { Button } from "@/components/ui/button";
 import {
   Card,
@@ -16,9 +17,10 @@ import { Send, Bot, User } from "lucide-react";
 
 export default function Home() {
   const [input, setInput] = React.useState("");
-  const { messages, sendMessage, status } = useChat({
+  const { messages, sendMessage, status, addToolApprovalResponse } = useChat({
     api: "/api/chat",
     streamProtocol: "data",
+    sendAutomaticallyWhen: lastAssistantMessageIsCompleteWithApprovalResponses,
   });
 
   const isLoading = status === "in-progress" || status === "streaming" || status === "submitted";
@@ -89,7 +91,11 @@ export default function Home() {
                   const hasTextContent = textContent && textContent.length > 0;
                   const hasToolCalls = toolCalls && toolCalls.length > 0;
 
-                  if (!hasTextContent && hasToolCalls) {
+                  const awaitingApproval = toolCalls.some((tool: any) =>
+                    tool.state === "approval-requested"
+                  );
+
+                  if (!hasTextContent && hasToolCalls && !awaitingApproval) {
                     return null;
                   }
 
@@ -166,6 +172,23 @@ export default function Home() {
                                 Error: {tool.errorText}
                               </div>
                             )}
+                            {tool.state === "approval-requested" && tool.approval && (
+                              <div className="ml-2 mt-2 flex gap-2">
+                                <Button
+                                  size="sm"
+                                  onClick={() => addToolApprovalResponse({ id: tool.approval.id, approved: true })}
+                                >
+                                  Approve
+                                </Button>
+                                <Button
+                                  size="sm"
+                                  variant="outline"
+                                  onClick={() => addToolApprovalResponse({ id: tool.approval.id, approved: false, reason: "denied by the user" })}
+                                >
+                                  Deny
+                                </Button>
+                              </div>
+                            )}
                           </div>
                         ))}
                       </div>
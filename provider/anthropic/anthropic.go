@@ -0,0 +1,595 @@
+package anthropic
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/alexisbouchez/palm/provider"
+	"github.com/alexisbouchez/palm/stream"
+)
+
+const (
+	baseURL    = "https://api.anthropic.com/v1"
+	apiVersion = "2023-06-01"
+)
+
+type anthropic struct {
+	apiKey     string
+	model      string
+	baseURL    string
+	retry      provider.RetryPolicy
+	httpClient *http.Client
+	options    provider.GenerationOptions
+}
+
+func generateID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func New() provider.Provider {
+	return &anthropic{
+		model:      "claude-sonnet-4-5",
+		baseURL:    baseURL,
+		retry:      provider.DefaultRetryPolicy(),
+		httpClient: http.DefaultClient,
+	}
+}
+
+func (a *anthropic) WithAPIKey(key string) provider.Provider {
+	a.apiKey = key
+	return a
+}
+
+func (a *anthropic) WithModel(model string) provider.Provider {
+	a.model = model
+	return a
+}
+
+func (a *anthropic) WithBaseURL(url string) provider.Provider {
+	a.baseURL = strings.TrimSuffix(url, "/")
+	return a
+}
+
+func (a *anthropic) WithRetryPolicy(policy provider.RetryPolicy) provider.Provider {
+	a.retry = policy
+	return a
+}
+
+func (a *anthropic) WithHTTPClient(client *http.Client) provider.Provider {
+	a.httpClient = client
+	return a
+}
+
+func (a *anthropic) WithGenerationOptions(opts provider.GenerationOptions) provider.Provider {
+	a.options = opts
+	return a
+}
+
+const defaultMaxTokens = 4096
+
+type messagesRequest struct {
+	Model         string      `json:"model"`
+	System        string      `json:"system,omitempty"`
+	Messages      []message   `json:"messages"`
+	Tools         []tool      `json:"tools,omitempty"`
+	ToolChoice    *toolChoice `json:"tool_choice,omitempty"`
+	MaxTokens     int         `json:"max_tokens"`
+	Stream        bool        `json:"stream,omitempty"`
+	Temperature   *float64    `json:"temperature,omitempty"`
+	TopP          *float64    `json:"top_p,omitempty"`
+	StopSequences []string    `json:"stop_sequences,omitempty"`
+}
+
+type message struct {
+	Role    string         `json:"role"`
+	Content []contentBlock `json:"content"`
+}
+
+type contentBlock struct {
+	Type      string          `json:"type"`
+	Text      string          `json:"text,omitempty"`
+	ID        string          `json:"id,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Input     json.RawMessage `json:"input,omitempty"`
+	ToolUseID string          `json:"tool_use_id,omitempty"`
+	Content   string          `json:"content,omitempty"`
+	Source    *source         `json:"source,omitempty"`
+}
+
+type source struct {
+	Type      string `json:"type"`
+	URL       string `json:"url,omitempty"`
+	MediaType string `json:"media_type,omitempty"`
+	Data      string `json:"data,omitempty"`
+}
+
+func toContentBlocks(parts []provider.ContentPart) []contentBlock {
+	blocks := make([]contentBlock, 0, len(parts))
+	for _, p := range parts {
+		switch {
+		case p.Type == provider.ContentTypeText:
+			blocks = append(blocks, contentBlock{Type: "text", Text: p.Text})
+		case p.Type == provider.ContentTypeImageURL:
+			blocks = append(blocks, contentBlock{Type: "image", Source: urlSource(p.URL)})
+		case p.Type == provider.ContentTypeDocumentURL:
+			blocks = append(blocks, contentBlock{Type: "document", Source: urlSource(p.URL)})
+		case strings.HasPrefix(p.MediaType, "text/"):
+			blocks = append(blocks, contentBlock{Type: "text", Text: string(p.Data)})
+		case p.IsImage():
+			blocks = append(blocks, contentBlock{Type: "image", Source: dataSource(p.MediaType, p.Data)})
+		default:
+			blocks = append(blocks, contentBlock{Type: "document", Source: dataSource(p.MediaType, p.Data)})
+		}
+	}
+	return blocks
+}
+
+func urlSource(url string) *source {
+	if mediaType, data, ok := provider.ParseDataURL(url); ok {
+		return dataSource(mediaType, data)
+	}
+	return &source{Type: "url", URL: url}
+}
+
+func dataSource(mediaType string, data []byte) *source {
+	return &source{
+		Type:      "base64",
+		MediaType: mediaType,
+		Data:      base64.StdEncoding.EncodeToString(data),
+	}
+}
+
+type tool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	InputSchema json.RawMessage `json:"input_schema"`
+}
+
+type messagesResponse struct {
+	ID         string         `json:"id"`
+	Content    []contentBlock `json:"content"`
+	StopReason string         `json:"stop_reason"`
+	Usage      usage          `json:"usage"`
+}
+
+type usage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+func (u usage) toUsage() provider.Usage {
+	return provider.Usage{
+		PromptTokens:     u.InputTokens,
+		CompletionTokens: u.OutputTokens,
+		TotalTokens:      u.InputTokens + u.OutputTokens,
+	}
+}
+
+type toolChoice struct {
+	Type                   string `json:"type"`
+	Name                   string `json:"name,omitempty"`
+	DisableParallelToolUse bool   `json:"disable_parallel_tool_use,omitempty"`
+}
+
+func toToolChoice(choice *provider.ToolChoice, parallel *bool) *toolChoice {
+	if choice == nil && parallel == nil {
+		return nil
+	}
+
+	tc := &toolChoice{Type: "auto"}
+	if choice != nil {
+		switch choice.Mode {
+		case provider.ToolChoiceNone:
+			tc.Type = "none"
+		case provider.ToolChoiceRequired:
+			tc.Type = "any"
+		case provider.ToolChoiceFunction:
+			tc.Type = "tool"
+			tc.Name = choice.Function
+		}
+	}
+	// Parallel tool use cannot be switched off when tools are disabled.
+	if parallel != nil && !*parallel && tc.Type != "none" {
+		tc.DisableParallelToolUse = true
+	}
+	return tc
+}
+
+func (a *anthropic) newMessagesRequest(ctx context.Context, messages []provider.Message, tools []provider.Tool) messagesRequest {
+	opts := a.options.Merge(provider.GenerationOptionsFromContext(ctx))
+	req := messagesRequest{
+		Model:         a.model,
+		MaxTokens:     defaultMaxTokens,
+		Temperature:   opts.Temperature,
+		TopP:          opts.TopP,
+		StopSequences: opts.Stop,
+	}
+	// The Messages API requires max_tokens on every request.
+	if opts.MaxTokens != nil {
+		req.MaxTokens = *opts.MaxTokens
+	}
+
+	var system []string
+	for _, msg := range messages {
+		var role string
+		var blocks []contentBlock
+
+		switch msg.Role {
+		case "system":
+			system = append(system, msg.Text())
+			continue
+		case "tool":
+			role = "user"
+			blocks = append(blocks, contentBlock{
+				Type:      "tool_result",
+				ToolUseID: msg.ToolCallID,
+				Content:   msg.Content,
+			})
+		case "assistant":
+			role = "assistant"
+			blocks = append(blocks, toContentBlocks(msg.ContentParts())...)
+			for _, tc := range msg.ToolCalls {
+				input := json.RawMessage(tc.Function.Arguments)
+				if !json.Valid(input) {
+					input = json.RawMessage("{}")
+				}
+				blocks = append(blocks, contentBlock{
+					Type:  "tool_use",
+					ID:    tc.ID,
+					Name:  tc.Function.Name,
+					Input: input,
+				})
+			}
+		default:
+			role = "user"
+			blocks = append(blocks, toContentBlocks(msg.ContentParts())...)
+		}
+
+		if len(blocks) == 0 {
+			continue
+		}
+
+		// The Messages API requires alternating roles, so tool results and
+		// follow-up user turns are merged into a single user message.
+		if n := len(req.Messages); n > 0 && req.Messages[n-1].Role == role {
+			req.Messages[n-1].Content = append(req.Messages[n-1].Content, blocks...)
+			continue
+		}
+		req.Messages = append(req.Messages, message{Role: role, Content: blocks})
+	}
+	req.System = strings.Join(system, "\n\n")
+
+	for _, t := range tools {
+		req.Tools = append(req.Tools, tool{
+			Name:        t.Function.Name,
+			Description: t.Function.Description,
+			InputSchema: t.Function.Parameters,
+		})
+	}
+	if len(tools) > 0 {
+		req.ToolChoice = toToolChoice(opts.ToolChoice, opts.ParallelToolCalls)
+	}
+
+	// The Messages API has no response format, so the reply is requested as
+	// the input of a forced tool call and turned back into text.
+	if schema := responseSchema(opts.ResponseFormat); schema != nil {
+		req.Tools = append(req.Tools, tool{
+			Name:        responseTool,
+			Description: "Reply with the final answer as this tool's input.",
+			InputSchema: schema,
+		})
+		req.ToolChoice = &toolChoice{Type: "tool", Name: responseTool}
+	}
+
+	return req
+}
+
+const responseTool = "json_response"
+
+func responseSchema(format *provider.ResponseFormat) json.RawMessage {
+	switch {
+	case format == nil:
+		return nil
+	case format.Type == provider.ResponseFormatJSONSchema && format.Schema != nil:
+		return format.Schema
+	case format.Type == provider.ResponseFormatJSONObject, format.Type == provider.ResponseFormatJSONSchema:
+		return json.RawMessage(`{"type":"object"}`)
+	default:
+		return nil
+	}
+}
+
+func (a *anthropic) post(ctx context.Context, req messagesRequest) (*http.Response, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request: %w", err)
+	}
+
+	return a.retry.Do(ctx, a.httpClient, func() (*http.Request, error) {
+		httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, a.baseURL+"/messages", bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("create request: %w", err)
+		}
+
+		httpReq.Header.Set("Content-Type", "application/json")
+		httpReq.Header.Set("x-api-key", a.apiKey)
+		httpReq.Header.Set("anthropic-version", apiVersion)
+
+		return httpReq, nil
+	})
+}
+
+func (a *anthropic) Chat(messages []provider.Message, tools []provider.Tool) (*provider.ChatResponse, error) {
+	return a.ChatContext(context.Background(), messages, tools)
+}
+
+func (a *anthropic) ChatContext(ctx context.Context, messages []provider.Message, tools []provider.Tool) (*provider.ChatResponse, error) {
+	resp, err := a.post(ctx, a.newMessagesRequest(ctx, messages, tools))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var msgResp messagesResponse
+	if err := json.NewDecoder(resp.Body).Decode(&msgResp); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+
+	msg := provider.Message{Role: "assistant"}
+	for _, block := range msgResp.Content {
+		switch block.Type {
+		case "text":
+			msg.Content += block.Text
+		case "tool_use":
+			if block.Name == responseTool {
+				msg.Content += string(block.Input)
+				continue
+			}
+			msg.ToolCalls = append(msg.ToolCalls, provider.ToolCall{
+				ID:   block.ID,
+				Type: "function",
+				Function: provider.FunctionCall{
+					Name:      block.Name,
+					Arguments: string(block.Input),
+				},
+			})
+		}
+	}
+
+	finishReason := toFinishReason(msgResp.StopReason)
+	if finishReason == provider.FinishReasonToolCalls && len(msg.ToolCalls) == 0 {
+		finishReason = provider.FinishReasonStop
+	}
+
+	return &provider.ChatResponse{
+		ID: msgResp.ID,
+		Choices: []provider.Choice{{
+			Message:      msg,
+			FinishReason: finishReason,
+		}},
+		Usage: msgResp.Usage.toUsage(),
+	}, nil
+}
+
+func toFinishReason(stopReason string) provider.FinishReason {
+	switch stopReason {
+	case "end_turn", "stop_sequence", "pause_turn":
+		return provider.FinishReasonStop
+	case "max_tokens":
+		return provider.FinishReasonLength
+	case "model_context_window_exceeded":
+		return provider.FinishReasonModelLength
+	case "tool_use":
+		return provider.FinishReasonToolCalls
+	case "refusal":
+		return provider.FinishReasonContentFilter
+	default:
+		return provider.FinishReason(stopReason)
+	}
+}
+
+type streamEvent struct {
+	Type         string            `json:"type"`
+	Index        int               `json:"index"`
+	Message      *messagesResponse `json:"message,omitempty"`
+	ContentBlock *contentBlock     `json:"content_block,omitempty"`
+	Delta        *streamDelta      `json:"delta,omitempty"`
+	Usage        *usage            `json:"usage,omitempty"`
+	Error        *streamError      `json:"error,omitempty"`
+}
+
+type streamDelta struct {
+	Type        string `json:"type"`
+	Text        string `json:"text,omitempty"`
+	PartialJSON string `json:"partial_json,omitempty"`
+	StopReason  string `json:"stop_reason,omitempty"`
+}
+
+type streamError struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+type streamBlock struct {
+	Type      string
+	ID        string
+	Name      string
+	Arguments string
+}
+
+func (a *anthropic) StreamChat(messages []provider.Message, tools []provider.Tool, writer io.Writer) (*provider.StreamResult, error) {
+	return a.StreamChatContext(context.Background(), messages, tools, writer)
+}
+
+func (a *anthropic) StreamChatContext(ctx context.Context, messages []provider.Message, tools []provider.Tool, writer io.Writer) (*provider.StreamResult, error) {
+	req := a.newMessagesRequest(ctx, messages, tools)
+	req.Stream = true
+
+	resp, err := a.post(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	emitter := stream.NewEmitter(writer)
+	emitter.Start(generateID())
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	blocks := make(map[int]*streamBlock)
+	var toolCalls []*streamBlock
+	var fullContent strings.Builder
+	var streamUsage usage
+	var finishReason provider.FinishReason
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+
+		var event streamEvent
+		if err := json.Unmarshal([]byte(strings.TrimSpace(strings.TrimPrefix(line, "data:"))), &event); err != nil {
+			continue
+		}
+
+		switch event.Type {
+		case "message_start":
+			if event.Message != nil {
+				streamUsage = event.Message.Usage
+			}
+
+		case "message_delta":
+			if event.Usage != nil {
+				streamUsage.OutputTokens = event.Usage.OutputTokens
+			}
+			if event.Delta != nil && event.Delta.StopReason != "" {
+				finishReason = toFinishReason(event.Delta.StopReason)
+			}
+
+		case "content_block_start":
+			if event.ContentBlock == nil {
+				continue
+			}
+			block := &streamBlock{
+				Type: event.ContentBlock.Type,
+				ID:   event.ContentBlock.ID,
+				Name: event.ContentBlock.Name,
+			}
+			blocks[event.Index] = block
+			if block.Type == "tool_use" && block.Name == responseTool {
+				block.Type = "text"
+			}
+
+			switch block.Type {
+			case "text":
+				block.ID = generateID()
+				emitter.TextStart(block.ID)
+			case "tool_use":
+				toolCalls = append(toolCalls, block)
+				emitter.ToolInputStart(block.ID, block.Name)
+			}
+
+		case "content_block_delta":
+			block, ok := blocks[event.Index]
+			if !ok || event.Delta == nil {
+				continue
+			}
+
+			switch event.Delta.Type {
+			case "text_delta":
+				emitter.TextDelta(block.ID, event.Delta.Text)
+				fullContent.WriteString(event.Delta.Text)
+			case "input_json_delta":
+				if event.Delta.PartialJSON == "" {
+					continue
+				}
+				if block.Type == "text" {
+					emitter.TextDelta(block.ID, event.Delta.PartialJSON)
+					fullContent.WriteString(event.Delta.PartialJSON)
+					continue
+				}
+				block.Arguments += event.Delta.PartialJSON
+				emitter.ToolInputDelta(block.ID, event.Delta.PartialJSON)
+			}
+
+		case "content_block_stop":
+			block, ok := blocks[event.Index]
+			if !ok {
+				continue
+			}
+
+			switch block.Type {
+			case "text":
+				emitter.TextEnd(block.ID)
+			case "tool_use":
+				if block.Arguments == "" {
+					block.Arguments = "{}"
+				}
+				var inputJSON any
+				if err := json.Unmarshal([]byte(block.Arguments), &inputJSON); err != nil {
+					slog.Warn("failed to parse tool input as JSON", "error", err, "args", block.Arguments)
+					inputJSON = block.Arguments
+				}
+				emitter.ToolInputAvailable(block.ID, block.Name, inputJSON)
+			}
+
+		case "error":
+			errMsg := "unknown error"
+			if event.Error != nil {
+				errMsg = event.Error.Type + ": " + event.Error.Message
+			}
+			emitter.Error(errMsg)
+			return nil, fmt.Errorf("stream error: %s", errMsg)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("scan stream: %w", err)
+	}
+
+	if finishReason == provider.FinishReasonToolCalls && len(toolCalls) == 0 {
+		finishReason = provider.FinishReasonStop
+	}
+
+	emitter.Finish(finishReason.StreamReason(), map[string]any{"usage": streamUsage.toUsage()})
+	emitter.Done()
+
+	result := &provider.StreamResult{
+		Usage:        streamUsage.toUsage(),
+		FinishReason: finishReason,
+		Message: provider.Message{
+			Role:    "assistant",
+			Content: fullContent.String(),
+		},
+	}
+
+	if len(toolCalls) > 0 {
+		result.Message.ToolCalls = make([]provider.ToolCall, 0, len(toolCalls))
+		for _, block := range toolCalls {
+			result.Message.ToolCalls = append(result.Message.ToolCalls, provider.ToolCall{
+				ID:   block.ID,
+				Type: "function",
+				Function: provider.FunctionCall{
+					Name:      block.Name,
+					Arguments: block.Arguments,
+				},
+			})
+		}
+	}
+
+	return result, nil
+}
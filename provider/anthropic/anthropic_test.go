@@ -0,0 +1,133 @@
+package anthropic
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alexisbouchez/palm/provider"
+)
+
+func TestToolChoice(t *testing.T) {
+	tests := []struct {
+		choice   *provider.ToolChoice
+		parallel *bool
+		want     *toolChoice
+	}{
+		{nil, nil, nil},
+		{provider.ToolChoiceOf(provider.ToolChoiceAuto), nil, &toolChoice{Type: "auto"}},
+		{provider.ToolChoiceOf(provider.ToolChoiceNone), provider.Ptr(false), &toolChoice{Type: "none"}},
+		{provider.ToolChoiceOf(provider.ToolChoiceRequired), nil, &toolChoice{Type: "any"}},
+		{provider.ForceTool("extract_fields"), provider.Ptr(false), &toolChoice{Type: "tool", Name: "extract_fields", DisableParallelToolUse: true}},
+		{nil, provider.Ptr(false), &toolChoice{Type: "auto", DisableParallelToolUse: true}},
+	}
+	for _, tt := range tests {
+		got := toToolChoice(tt.choice, tt.parallel)
+		if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+			t.Errorf("toToolChoice(%+v, %v) = %+v, want %+v", tt.choice, tt.parallel, got, tt.want)
+		}
+	}
+}
+
+var answerFormat = &provider.ResponseFormat{
+	Type:   provider.ResponseFormatJSONSchema,
+	Name:   "answer",
+	Schema: json.RawMessage(`{"type":"object","properties":{"city":{"type":"string"}}}`),
+}
+
+func TestResponseFormatForcesTool(t *testing.T) {
+	p := New().WithGenerationOptions(provider.GenerationOptions{ResponseFormat: answerFormat}).(*anthropic)
+	req := p.newMessagesRequest(context.Background(), []provider.Message{{Role: "user", Content: "where?"}}, nil)
+
+	if len(req.Tools) != 1 || req.Tools[0].Name != responseTool || string(req.Tools[0].InputSchema) != string(answerFormat.Schema) {
+		t.Errorf("tools = %+v, want the response tool with the schema", req.Tools)
+	}
+	if req.ToolChoice == nil || req.ToolChoice.Type != "tool" || req.ToolChoice.Name != responseTool {
+		t.Errorf("tool_choice = %+v, want the response tool forced", req.ToolChoice)
+	}
+
+	plain := New().(*anthropic).newMessagesRequest(context.Background(), []provider.Message{{Role: "user", Content: "where?"}}, nil)
+	if plain.Tools != nil || plain.ToolChoice != nil {
+		t.Errorf("tools sent without a response format: %+v, %+v", plain.Tools, plain.ToolChoice)
+	}
+}
+
+func sse(events ...string) string {
+	var b strings.Builder
+	for _, e := range events {
+		var typ struct {
+			Type string `json:"type"`
+		}
+		json.Unmarshal([]byte(e), &typ)
+		fmt.Fprintf(&b, "event: %s\ndata: %s\n\n", typ.Type, e)
+	}
+	return b.String()
+}
+
+func TestResponseToolStreamsAsText(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, sse(
+			`{"type":"message_start","message":{"id":"msg_1","usage":{"input_tokens":10,"output_tokens":1}}}`,
+			`{"type":"content_block_start","index":0,"content_block":{"type":"tool_use","id":"toolu_1","name":"json_response","input":{}}}`,
+			`{"type":"content_block_delta","index":0,"delta":{"type":"input_json_delta","partial_json":""}}`,
+			`{"type":"content_block_delta","index":0,"delta":{"type":"input_json_delta","partial_json":"{\"city\":"}}`,
+			`{"type":"content_block_delta","index":0,"delta":{"type":"input_json_delta","partial_json":"\"Paris\"}"}}`,
+			`{"type":"content_block_stop","index":0}`,
+			`{"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":8}}`,
+			`{"type":"message_stop"}`,
+		))
+	}))
+	defer srv.Close()
+
+	p := New().WithBaseURL(srv.URL).WithGenerationOptions(provider.GenerationOptions{ResponseFormat: answerFormat})
+	var out bytes.Buffer
+	result, err := p.StreamChatContext(context.Background(), []provider.Message{{Role: "user", Content: "where?"}}, nil, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Message.Content != `{"city":"Paris"}` || len(result.Message.ToolCalls) != 0 {
+		t.Errorf("message = %+v, want the JSON as text", result.Message)
+	}
+	if result.FinishReason != provider.FinishReasonStop {
+		t.Errorf("finish reason = %q, want stop", result.FinishReason)
+	}
+	if strings.Contains(out.String(), "tool-input") {
+		t.Errorf("response tool streamed as a tool call:\n%s", out.String())
+	}
+	if !strings.Contains(out.String(), `"type":"text-start"`) || !strings.Contains(out.String(), `"type":"text-end"`) {
+		t.Errorf("no text part in:\n%s", out.String())
+	}
+}
+
+func TestResponseToolChat(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{
+			"id": "msg_1",
+			"content": [{"type": "tool_use", "id": "toolu_1", "name": "json_response", "input": {"city": "Rome"}}],
+			"stop_reason": "tool_use",
+			"usage": {"input_tokens": 10, "output_tokens": 5}
+		}`)
+	}))
+	defer srv.Close()
+
+	p := New().WithBaseURL(srv.URL).WithGenerationOptions(provider.GenerationOptions{ResponseFormat: answerFormat})
+	resp, err := p.ChatContext(context.Background(), []provider.Message{{Role: "user", Content: "where?"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	choice := resp.Choices[0]
+	if choice.Message.Content != `{"city": "Rome"}` || len(choice.Message.ToolCalls) != 0 {
+		t.Errorf("message = %+v, want the JSON as text", choice.Message)
+	}
+	if choice.FinishReason != provider.FinishReasonStop {
+		t.Errorf("finish reason = %q, want stop", choice.FinishReason)
+	}
+}
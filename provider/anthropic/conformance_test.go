@@ -0,0 +1,18 @@
+package anthropic_test
+
+import (
+	"testing"
+
+	"github.com/alexisbouchez/palm/provider"
+	"github.com/alexisbouchez/palm/provider/anthropic"
+	"github.com/alexisbouchez/palm/provider/providertest"
+)
+
+func TestConformance(t *testing.T) {
+	providertest.RunConformance(t, providertest.Factory{
+		Wire: providertest.AnthropicWire,
+		New: func(baseURL string) provider.Provider {
+			return anthropic.New().WithAPIKey("test").WithBaseURL(baseURL)
+		},
+	})
+}
@@ -0,0 +1,288 @@
+// Package cassette records the HTTP traffic of a provider to a file and
+// replays it, so agents can be tested without network access or API keys.
+//
+// A cassette is an http.RoundTripper; hand its Client to a provider:
+//
+//	c := cassette.New("testdata/weather.json", cassette.ModeAuto)
+//	p := mistral.New().WithAPIKey(os.Getenv("MISTRAL_API_KEY")).WithHTTPClient(c.Client())
+//
+// Recording stores each request and the raw response body, and replay
+// serves that body back byte for byte, so the provider parses exactly what
+// the API sent. Requests are matched in order on method, path, query and
+// body. Request headers, including the API key, are never recorded.
+package cassette
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+type Mode int
+
+const (
+	// ModeReplay serves requests from the cassette file and never touches
+	// the network.
+	ModeReplay Mode = iota
+	// ModeRecord sends requests through the transport and overwrites the
+	// cassette file with what came back.
+	ModeRecord
+	// ModeAuto replays when the cassette file exists and records otherwise.
+	ModeAuto
+)
+
+var ErrRequestMismatch = errors.New("request does not match cassette")
+
+// recordedHeaders are the response headers worth keeping: the ones
+// providers read. Everything else varies between runs.
+var recordedHeaders = []string{"Content-Type", "Retry-After"}
+
+type Request struct {
+	Method string `json:"method"`
+	// URL is the path and query; the host is left out so that recordings
+	// replay against any base URL with the same path.
+	URL  string          `json:"url"`
+	Body json.RawMessage `json:"body,omitempty"`
+}
+
+type Response struct {
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       string      `json:"body"`
+}
+
+type Interaction struct {
+	Request  Request   `json:"request"`
+	Response *Response `json:"response,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+type Cassette interface {
+	http.RoundTripper
+
+	// WithTransport sets the transport recordings are made through. It
+	// defaults to http.DefaultTransport.
+	WithTransport(transport http.RoundTripper) Cassette
+	// Client returns an HTTP client that sends its requests through the
+	// cassette.
+	Client() *http.Client
+}
+
+type cassette struct {
+	path      string
+	mode      Mode
+	transport http.RoundTripper
+
+	mu           sync.Mutex
+	interactions []Interaction
+	next         int
+	err          error
+}
+
+func New(path string, mode Mode) Cassette {
+	c := &cassette{path: path, mode: mode, transport: http.DefaultTransport}
+	if mode == ModeAuto {
+		c.mode = ModeRecord
+		if _, err := os.Stat(path); err == nil {
+			c.mode = ModeReplay
+		}
+	}
+	if c.mode == ModeReplay {
+		c.err = c.load()
+	}
+	return c
+}
+
+func (c *cassette) WithTransport(transport http.RoundTripper) Cassette {
+	c.transport = transport
+	return c
+}
+
+func (c *cassette) Client() *http.Client {
+	return &http.Client{Transport: c}
+}
+
+func (c *cassette) load() error {
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		return fmt.Errorf("read cassette: %w", err)
+	}
+	if err := json.Unmarshal(data, &c.interactions); err != nil {
+		return fmt.Errorf("parse cassette %s: %w", c.path, err)
+	}
+	return nil
+}
+
+// save writes the cassette file. c.mu must be held.
+func (c *cassette) save() error {
+	data, err := json.MarshalIndent(c.interactions, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal cassette: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o755); err != nil {
+		return fmt.Errorf("create cassette dir: %w", err)
+	}
+	if err := os.WriteFile(c.path, data, 0o644); err != nil {
+		return fmt.Errorf("write cassette: %w", err)
+	}
+	return nil
+}
+
+// newRequest reads the body of req and describes it for the cassette. JSON
+// bodies are kept as JSON so that cassette files stay readable.
+func newRequest(req *http.Request) (Request, []byte, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return Request{}, nil, fmt.Errorf("read request body: %w", err)
+		}
+		req.Body.Close()
+	}
+
+	recorded := Request{Method: req.Method, URL: req.URL.RequestURI()}
+	switch {
+	case len(body) == 0:
+	case json.Valid(body):
+		recorded.Body = body
+	default:
+		recorded.Body, _ = json.Marshal(string(body))
+	}
+	return recorded, body, nil
+}
+
+func (c *cassette) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	recorded, body, err := newRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.mode == ModeReplay {
+		interaction, err := c.replay(recorded)
+		if err != nil {
+			return nil, err
+		}
+		if interaction.Error != "" {
+			return nil, errors.New(interaction.Error)
+		}
+		return interaction.Response.toHTTP(req), nil
+	}
+
+	out := req.Clone(req.Context())
+	out.Body = io.NopCloser(bytes.NewReader(body))
+	out.ContentLength = int64(len(body))
+
+	c.interactions = append(c.interactions, Interaction{Request: recorded})
+	index := len(c.interactions) - 1
+
+	resp, err := c.transport.RoundTrip(out)
+	if err != nil {
+		c.interactions[index].Error = err.Error()
+		if saveErr := c.save(); saveErr != nil {
+			return nil, saveErr
+		}
+		return nil, err
+	}
+
+	header := http.Header{}
+	for _, key := range recordedHeaders {
+		if values := resp.Header.Values(key); len(values) > 0 {
+			header[key] = values
+		}
+	}
+	resp.Body = &recordingBody{
+		ReadCloser: resp.Body,
+		save: func(body []byte) error {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			c.interactions[index].Response = &Response{StatusCode: resp.StatusCode, Header: header, Body: string(body)}
+			return c.save()
+		},
+	}
+	return resp, nil
+}
+
+// replay returns the next recorded interaction, or an error with a diff
+// between the recorded request and req when they differ.
+func (c *cassette) replay(req Request) (*Interaction, error) {
+	got, err := normalize(req)
+	if err != nil {
+		return nil, err
+	}
+	if c.next >= len(c.interactions) {
+		return nil, fmt.Errorf("%w: no recorded interaction left for request %d:\n%s", ErrRequestMismatch, c.next, got)
+	}
+
+	interaction := &c.interactions[c.next]
+	want, err := normalize(interaction.Request)
+	if err != nil {
+		return nil, err
+	}
+	if got != want {
+		return nil, fmt.Errorf("%w: request %d differs (-recorded +actual):\n%s", ErrRequestMismatch, c.next, diff(want, got))
+	}
+	if interaction.Response == nil && interaction.Error == "" {
+		return nil, fmt.Errorf("%w: request %d has no recorded response", ErrRequestMismatch, c.next)
+	}
+
+	c.next++
+	return interaction, nil
+}
+
+func (r *Response) toHTTP(req *http.Request) *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", r.StatusCode, http.StatusText(r.StatusCode)),
+		StatusCode:    r.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        r.Header.Clone(),
+		Body:          io.NopCloser(strings.NewReader(r.Body)),
+		ContentLength: int64(len(r.Body)),
+		Request:       req,
+	}
+}
+
+// recordingBody keeps a copy of everything read from the response and saves
+// it on Close. Whatever the reader left unread is drained first, so the
+// cassette always holds the full body even when parsing stops at [DONE].
+type recordingBody struct {
+	io.ReadCloser
+	buf    bytes.Buffer
+	save   func(body []byte) error
+	closed bool
+}
+
+func (b *recordingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.buf.Write(p[:n])
+	return n, err
+}
+
+func (b *recordingBody) Close() error {
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+
+	io.Copy(&b.buf, b.ReadCloser)
+	err := b.ReadCloser.Close()
+	if saveErr := b.save(b.buf.Bytes()); saveErr != nil {
+		return saveErr
+	}
+	return err
+}
@@ -0,0 +1,259 @@
+package cassette
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/alexisbouchez/palm/provider"
+	"github.com/alexisbouchez/palm/provider/mistral"
+)
+
+var weatherTool = provider.Tool{
+	Type: "function",
+	Function: provider.ToolFunction{
+		Name:        "get_weather",
+		Description: "Get the current weather for a location",
+		Parameters:  json.RawMessage(`{"type":"object","properties":{"location":{"type":"string"}},"required":["location"]}`),
+	},
+}
+
+func userMessage(text string) []provider.Message {
+	return []provider.Message{{Role: "user", Content: text}}
+}
+
+func replayMistral(path string) provider.Provider {
+	return mistral.New().
+		WithRetryPolicy(provider.RetryPolicy{MaxAttempts: 1}).
+		WithHTTPClient(New(path, ModeReplay).Client())
+}
+
+func TestReplayMistralStream(t *testing.T) {
+	p := replayMistral("testdata/mistral_text.json")
+
+	var out bytes.Buffer
+	result, err := p.StreamChatContext(context.Background(), userMessage("What is the capital of France?"), nil, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Message.Content != "The capital of France is **Paris**." {
+		t.Errorf("content = %q", result.Message.Content)
+	}
+	if result.FinishReason != provider.FinishReasonStop || result.Usage.TotalTokens != 18 {
+		t.Errorf("finish reason = %q, usage = %+v", result.FinishReason, result.Usage)
+	}
+	if !strings.Contains(out.String(), `"delta":" capital of France"`) {
+		t.Errorf("stream is missing a text delta:\n%s", out.String())
+	}
+}
+
+func TestReplayMistralToolCalls(t *testing.T) {
+	p := replayMistral("testdata/mistral_tools.json")
+	tools := []provider.Tool{weatherTool}
+
+	messages := userMessage("What's the weather in Paris?")
+	result, err := p.StreamChatContext(context.Background(), messages, tools, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := result.Message.ToolCalls
+	if len(calls) != 1 || calls[0].Function.Name != "get_weather" || calls[0].Function.Arguments != `{"location": "Paris"}` {
+		t.Fatalf("tool calls = %+v", calls)
+	}
+
+	messages = append(messages, result.Message, provider.Message{Role: "tool", ToolCallID: calls[0].ID, Content: "18°C, sunny"})
+	result, err = p.StreamChatContext(context.Background(), messages, tools, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Message.Content != "It is 18°C and sunny in Paris." {
+		t.Errorf("content = %q", result.Message.Content)
+	}
+}
+
+func TestReplayMismatchShowsDiff(t *testing.T) {
+	p := replayMistral("testdata/mistral_text.json")
+
+	var out bytes.Buffer
+	_, err := p.StreamChatContext(context.Background(), userMessage("What is the capital of Italy?"), nil, &out)
+	if !errors.Is(err, ErrRequestMismatch) {
+		t.Fatalf("err = %v, want ErrRequestMismatch", err)
+	}
+	for _, line := range []string{`-         "content": "What is the capital of France?"`, `+         "content": "What is the capital of Italy?"`} {
+		if !strings.Contains(err.Error(), line) {
+			t.Errorf("diff is missing %q:\n%v", line, err)
+		}
+	}
+	if out.Len() > 0 {
+		t.Errorf("wrote %q on mismatch", out.String())
+	}
+}
+
+func TestReplayFailsWhenCassetteIsExhausted(t *testing.T) {
+	p := replayMistral("testdata/mistral_text.json")
+
+	if _, err := p.StreamChatContext(context.Background(), userMessage("What is the capital of France?"), nil, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.StreamChatContext(context.Background(), userMessage("What is the capital of France?"), nil, io.Discard); !errors.Is(err, ErrRequestMismatch) {
+		t.Errorf("err = %v, want ErrRequestMismatch", err)
+	}
+}
+
+func TestReplayMissingCassette(t *testing.T) {
+	_, err := New(filepath.Join(t.TempDir(), "missing.json"), ModeReplay).Client().Get("http://example.com/")
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("err = %v, want os.ErrNotExist", err)
+	}
+}
+
+// sseServer serves body with the given status and counts requests.
+func sseServer(t *testing.T, status int, body string) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("X-Request-Id", "changes-every-time")
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &requests
+}
+
+func TestRecordThenReplayByteForByte(t *testing.T) {
+	// Irregular spacing, CRLF line endings and a trailing comment line must
+	// all survive the round trip.
+	body := "data: {\"a\": 1}\r\n\r\n: ping\n\ndata:{\"b\":\"é\\u00e9\"}\n\ndata: [DONE]\n\n: trailing"
+	srv, requests := sseServer(t, http.StatusOK, body)
+	path := filepath.Join(t.TempDir(), "raw.json")
+
+	send := func(c Cassette) string {
+		t.Helper()
+		resp, err := c.Client().Post(srv.URL+"/v1/chat/completions?stream=1", "application/json", strings.NewReader(`{"model":"m","stream":true}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.Header.Get("Content-Type") != "text/event-stream" {
+			t.Errorf("Content-Type = %q", resp.Header.Get("Content-Type"))
+		}
+		data, _ := io.ReadAll(resp.Body)
+		return string(data)
+	}
+
+	if got := send(New(path, ModeRecord)); got != body {
+		t.Errorf("recording changed the body: %q", got)
+	}
+	if got := send(New(path, ModeReplay)); got != body {
+		t.Errorf("replayed body = %q\nwant %q", got, body)
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("server got %d requests, want 1", n)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "X-Request-Id") {
+		t.Errorf("volatile header recorded:\n%s", data)
+	}
+}
+
+// A provider that stops reading at [DONE] still leaves the whole body in
+// the cassette.
+func TestRecordDrainsUnreadBody(t *testing.T) {
+	body := "data: [DONE]\n\ndata: {\"late\":true}\n\n"
+	srv, _ := sseServer(t, http.StatusOK, body)
+	path := filepath.Join(t.TempDir(), "drain.json")
+
+	resp, err := New(path, ModeRecord).Client().Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	resp, err = New(path, ModeReplay).Client().Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if data, _ := io.ReadAll(resp.Body); string(data) != body {
+		t.Errorf("replayed body = %q, want %q", data, body)
+	}
+}
+
+func TestRecordKeepsAPIKeyOut(t *testing.T) {
+	srv, _ := sseServer(t, http.StatusOK, "data: [DONE]\n\n")
+	path := filepath.Join(t.TempDir(), "key.json")
+
+	p := mistral.New().WithAPIKey("sk-secret").WithBaseURL(srv.URL).WithHTTPClient(New(path, ModeRecord).Client())
+	if _, err := p.StreamChatContext(context.Background(), userMessage("hi"), nil, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "sk-secret") {
+		t.Errorf("API key recorded:\n%s", data)
+	}
+}
+
+func TestReplayErrorStatus(t *testing.T) {
+	srv, _ := sseServer(t, http.StatusUnauthorized, `{"message":"Unauthorized","request_id":"abc"}`)
+	path := filepath.Join(t.TempDir(), "401.json")
+
+	newProvider := func(mode Mode) provider.Provider {
+		return mistral.New().WithBaseURL(srv.URL).WithHTTPClient(New(path, mode).Client())
+	}
+	for _, mode := range []Mode{ModeRecord, ModeReplay} {
+		_, err := newProvider(mode).StreamChatContext(context.Background(), userMessage("hi"), nil, io.Discard)
+		var apiErr *provider.APIError
+		if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized || !strings.Contains(apiErr.Body, "Unauthorized") {
+			t.Errorf("mode %d: err = %v, want the 401", mode, err)
+		}
+	}
+}
+
+func TestAutoRecordsOnceThenReplays(t *testing.T) {
+	srv, requests := sseServer(t, http.StatusOK, "data: [DONE]\n\n")
+	path := filepath.Join(t.TempDir(), "auto.json")
+
+	for range 2 {
+		p := mistral.New().WithBaseURL(srv.URL).WithHTTPClient(New(path, ModeAuto).Client())
+		if _, err := p.StreamChatContext(context.Background(), userMessage("hi"), nil, io.Discard); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("server got %d requests, want 1", n)
+	}
+}
+
+func TestNormalizeIgnoresKeyOrder(t *testing.T) {
+	a, err := normalize(Request{Method: "POST", URL: "/v1/x", Body: json.RawMessage(`{"b":1,"a":{"d":2,"c":3}}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := normalize(Request{Method: "POST", URL: "/v1/x", Body: json.RawMessage(`{"a": {"c": 3, "d": 2}, "b": 1}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a != b {
+		t.Errorf("normalized requests differ:\n%s", diff(a, b))
+	}
+}
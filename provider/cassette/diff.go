@@ -0,0 +1,72 @@
+package cassette
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// normalize renders req as indented JSON with sorted keys so that equal
+// requests compare equal and differences show up line by line.
+func normalize(req Request) (string, error) {
+	var body any
+	if len(req.Body) > 0 {
+		dec := json.NewDecoder(bytes.NewReader(req.Body))
+		dec.UseNumber()
+		if err := dec.Decode(&body); err != nil {
+			return "", fmt.Errorf("decode request body: %w", err)
+		}
+	}
+
+	data, err := json.MarshalIndent(struct {
+		Method string `json:"method"`
+		URL    string `json:"url"`
+		Body   any    `json:"body,omitempty"`
+	}{req.Method, req.URL, body}, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("marshal request: %w", err)
+	}
+	return string(data), nil
+}
+
+// diff returns a line diff of a and b, with - for lines only in a and + for
+// lines only in b.
+func diff(a, b string) string {
+	x := strings.Split(a, "\n")
+	y := strings.Split(b, "\n")
+
+	// lcs[i][j] is the length of the longest common subsequence of x[i:]
+	// and y[j:].
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var sb strings.Builder
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			sb.WriteString("  " + x[i] + "\n")
+			i++
+			j++
+		case i < len(x) && (j == len(y) || lcs[i+1][j] >= lcs[i][j+1]):
+			sb.WriteString("- " + x[i] + "\n")
+			i++
+		default:
+			sb.WriteString("+ " + y[j] + "\n")
+			j++
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,29 @@
+package provider
+
+import (
+	"context"
+	"io"
+	"net/http"
+)
+
+// Completer fills in the middle of a document: given the text before and
+// after the cursor, it generates what goes in between.
+type Completer interface {
+	WithAPIKey(key string) Completer
+	WithModel(model string) Completer
+	WithBaseURL(url string) Completer
+	WithRetryPolicy(policy RetryPolicy) Completer
+	WithHTTPClient(client *http.Client) Completer
+	WithGenerationOptions(opts GenerationOptions) Completer
+
+	Complete(ctx context.Context, prefix, suffix string) (*Completion, error)
+	// StreamComplete writes the generated text to writer as it arrives,
+	// as plain text rather than an event stream.
+	StreamComplete(ctx context.Context, prefix, suffix string, writer io.Writer) (*Completion, error)
+}
+
+type Completion struct {
+	Text         string
+	Usage        Usage
+	FinishReason FinishReason
+}
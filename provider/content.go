@@ -0,0 +1,103 @@
+package provider
+
+import (
+	"encoding/base64"
+	"strings"
+)
+
+type ContentType string
+
+const (
+	ContentTypeText        ContentType = "text"
+	ContentTypeImageURL    ContentType = "image_url"
+	ContentTypeDocumentURL ContentType = "document_url"
+	ContentTypeData        ContentType = "data"
+)
+
+// ContentPart is one piece of a multimodal message. Image and document parts
+// point at a URL; data parts carry the raw bytes along with their media type
+// and are mapped by each provider onto whatever its API accepts.
+type ContentPart struct {
+	Type      ContentType `json:"type"`
+	Text      string      `json:"text,omitempty"`
+	URL       string      `json:"url,omitempty"`
+	Name      string      `json:"name,omitempty"`
+	MediaType string      `json:"media_type,omitempty"`
+	Data      []byte      `json:"data,omitempty"`
+}
+
+func TextPart(text string) ContentPart {
+	return ContentPart{Type: ContentTypeText, Text: text}
+}
+
+func ImageURLPart(url string) ContentPart {
+	return ContentPart{Type: ContentTypeImageURL, URL: url}
+}
+
+func DocumentURLPart(url, name string) ContentPart {
+	return ContentPart{Type: ContentTypeDocumentURL, URL: url, Name: name}
+}
+
+func DataPart(mediaType string, data []byte) ContentPart {
+	return ContentPart{Type: ContentTypeData, MediaType: mediaType, Data: data}
+}
+
+func (p ContentPart) IsImage() bool {
+	return p.Type == ContentTypeImageURL || (p.Type == ContentTypeData && strings.HasPrefix(p.MediaType, "image/"))
+}
+
+func (p ContentPart) DataURL() string {
+	return "data:" + p.MediaType + ";base64," + base64.StdEncoding.EncodeToString(p.Data)
+}
+
+// ParseDataURL splits a base64 data URL into its media type and bytes.
+func ParseDataURL(url string) (string, []byte, bool) {
+	rest, ok := strings.CutPrefix(url, "data:")
+	if !ok {
+		return "", nil, false
+	}
+
+	meta, payload, ok := strings.Cut(rest, ",")
+	if !ok {
+		return "", nil, false
+	}
+
+	mediaType, ok := strings.CutSuffix(meta, ";base64")
+	if !ok {
+		return "", nil, false
+	}
+
+	data, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return "", nil, false
+	}
+
+	return mediaType, data, true
+}
+
+// ContentParts returns the message content as parts, wrapping plain string
+// content in a single text part.
+func (m Message) ContentParts() []ContentPart {
+	if len(m.Parts) > 0 {
+		return m.Parts
+	}
+	if m.Content == "" {
+		return nil
+	}
+	return []ContentPart{TextPart(m.Content)}
+}
+
+// Text returns the textual content of the message, ignoring other parts.
+func (m Message) Text() string {
+	if len(m.Parts) == 0 {
+		return m.Content
+	}
+
+	var sb strings.Builder
+	for _, p := range m.Parts {
+		if p.Type == ContentTypeText {
+			sb.WriteString(p.Text)
+		}
+	}
+	return sb.String()
+}
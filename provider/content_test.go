@@ -0,0 +1,68 @@
+package provider
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestDataURLRoundTrip(t *testing.T) {
+	part := DataPart("image/png", []byte{0x89, 'P', 'N', 'G'})
+
+	mediaType, data, ok := ParseDataURL(part.DataURL())
+	if !ok || mediaType != "image/png" || !bytes.Equal(data, part.Data) {
+		t.Errorf("ParseDataURL(%q) = %q, %v, %v", part.DataURL(), mediaType, data, ok)
+	}
+
+	for _, url := range []string{
+		"https://example.com/cat.png",
+		"data:image/png,not-base64",
+		"data:image/png;base64",
+		"data:image/png;base64,%%%",
+	} {
+		if _, _, ok := ParseDataURL(url); ok {
+			t.Errorf("ParseDataURL(%q) succeeded", url)
+		}
+	}
+}
+
+func TestIsImage(t *testing.T) {
+	tests := []struct {
+		part ContentPart
+		want bool
+	}{
+		{ImageURLPart("https://example.com/cat.png"), true},
+		{DataPart("image/jpeg", nil), true},
+		{DataPart("application/pdf", nil), false},
+		{DocumentURLPart("https://example.com/a.pdf", ""), false},
+		{TextPart("hi"), false},
+	}
+	for _, tt := range tests {
+		if got := tt.part.IsImage(); got != tt.want {
+			t.Errorf("%+v.IsImage() = %v, want %v", tt.part, got, tt.want)
+		}
+	}
+}
+
+func TestMessageText(t *testing.T) {
+	plain := Message{Role: "user", Content: "hello"}
+	if plain.Text() != "hello" || !reflect.DeepEqual(plain.ContentParts(), []ContentPart{TextPart("hello")}) {
+		t.Errorf("plain message: Text %q, parts %+v", plain.Text(), plain.ContentParts())
+	}
+
+	multi := Message{Role: "user", Content: "ignored", Parts: []ContentPart{
+		TextPart("look at "),
+		ImageURLPart("https://example.com/cat.png"),
+		TextPart("this"),
+	}}
+	if multi.Text() != "look at this" {
+		t.Errorf("Text = %q, want the text parts only", multi.Text())
+	}
+	if len(multi.ContentParts()) != 3 {
+		t.Errorf("ContentParts = %+v", multi.ContentParts())
+	}
+
+	if (Message{Role: "assistant"}).ContentParts() != nil {
+		t.Error("empty message has parts")
+	}
+}
@@ -0,0 +1,23 @@
+package provider
+
+import (
+	"context"
+	"net/http"
+)
+
+// Embedder turns text into vectors. Embed returns one vector per input, in
+// input order, splitting large inputs into batches as the API requires.
+type Embedder interface {
+	WithAPIKey(key string) Embedder
+	WithModel(model string) Embedder
+	WithBaseURL(url string) Embedder
+	WithRetryPolicy(policy RetryPolicy) Embedder
+	WithHTTPClient(client *http.Client) Embedder
+	WithBatchSize(n int) Embedder
+	WithDimensions(n int) Embedder
+
+	// Dimensions is the length of the vectors returned by Embed, or 0 if
+	// it is not known until the first call.
+	Dimensions() int
+	Embed(ctx context.Context, inputs []string) ([][]float32, error)
+}
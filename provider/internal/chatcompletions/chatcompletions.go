@@ -0,0 +1,127 @@
+// Package chatcompletions is the /chat/completions wire format that OpenAI
+// defined and Mistral and most self-hosted servers follow. Providers embed
+// Request to add their own fields and supply how message parts and delta
+// content are encoded.
+package chatcompletions
+
+import (
+	"encoding/json"
+
+	"github.com/alexisbouchez/palm/provider"
+)
+
+type Request struct {
+	Model             string          `json:"model"`
+	Messages          []Message       `json:"messages"`
+	Tools             []provider.Tool `json:"tools,omitempty"`
+	Stream            bool            `json:"stream,omitempty"`
+	Temperature       *float64        `json:"temperature,omitempty"`
+	TopP              *float64        `json:"top_p,omitempty"`
+	MaxTokens         *int            `json:"max_tokens,omitempty"`
+	Stop              []string        `json:"stop,omitempty"`
+	PresencePenalty   *float64        `json:"presence_penalty,omitempty"`
+	FrequencyPenalty  *float64        `json:"frequency_penalty,omitempty"`
+	ResponseFormat    *ResponseFormat `json:"response_format,omitempty"`
+	ToolChoice        any             `json:"tool_choice,omitempty"`
+	ParallelToolCalls *bool           `json:"parallel_tool_calls,omitempty"`
+}
+
+// NewRequest maps the options every vendor shares. required is the vendor's
+// tool_choice value for provider.ToolChoiceRequired.
+func NewRequest(model string, messages []Message, tools []provider.Tool, opts provider.GenerationOptions, required string) Request {
+	req := Request{
+		Model:            model,
+		Messages:         messages,
+		Tools:            tools,
+		Temperature:      opts.Temperature,
+		TopP:             opts.TopP,
+		MaxTokens:        opts.MaxTokens,
+		Stop:             opts.Stop,
+		PresencePenalty:  opts.PresencePenalty,
+		FrequencyPenalty: opts.FrequencyPenalty,
+		ResponseFormat:   toResponseFormat(opts.ResponseFormat),
+	}
+	// Tool settings are rejected by the APIs when no tools are sent.
+	if len(tools) > 0 {
+		req.ToolChoice = toToolChoice(opts.ToolChoice, required)
+		req.ParallelToolCalls = opts.ParallelToolCalls
+	}
+	return req
+}
+
+type Message struct {
+	Role       string              `json:"role"`
+	Content    any                 `json:"content,omitempty"`
+	ToolCalls  []provider.ToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string              `json:"tool_call_id,omitempty"`
+}
+
+// ToMessages converts messages, encoding multimodal parts with toParts.
+// Content is only set when there is some, since an empty string inside an
+// interface would not be omitted.
+func ToMessages[P any](messages []provider.Message, toParts func([]provider.ContentPart) []P) []Message {
+	out := make([]Message, 0, len(messages))
+	for _, msg := range messages {
+		m := Message{
+			Role:       msg.Role,
+			ToolCalls:  msg.ToolCalls,
+			ToolCallID: msg.ToolCallID,
+		}
+		if msg.Content != "" {
+			m.Content = msg.Content
+		}
+		if len(msg.Parts) > 0 {
+			m.Content = toParts(msg.Parts)
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+type ResponseFormat struct {
+	Type       string      `json:"type"`
+	JSONSchema *JSONSchema `json:"json_schema,omitempty"`
+}
+
+type JSONSchema struct {
+	Name   string          `json:"name"`
+	Schema json.RawMessage `json:"schema"`
+	Strict bool            `json:"strict,omitempty"`
+}
+
+func toResponseFormat(format *provider.ResponseFormat) *ResponseFormat {
+	if format == nil {
+		return nil
+	}
+	rf := &ResponseFormat{Type: string(format.Type)}
+	if format.Type == provider.ResponseFormatJSONSchema {
+		rf.JSONSchema = &JSONSchema{
+			Name:   format.Name,
+			Schema: format.Schema,
+			Strict: format.Strict,
+		}
+	}
+	return rf
+}
+
+type toolChoiceFunction struct {
+	Type     string `json:"type"`
+	Function struct {
+		Name string `json:"name"`
+	} `json:"function"`
+}
+
+func toToolChoice(choice *provider.ToolChoice, required string) any {
+	switch {
+	case choice == nil:
+		return nil
+	case choice.Mode == provider.ToolChoiceFunction:
+		tc := toolChoiceFunction{Type: "function"}
+		tc.Function.Name = choice.Function
+		return tc
+	case choice.Mode == provider.ToolChoiceRequired:
+		return required
+	default:
+		return string(choice.Mode)
+	}
+}
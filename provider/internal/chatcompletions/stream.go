@@ -0,0 +1,273 @@
+package chatcompletions
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/alexisbouchez/palm/provider"
+	"github.com/alexisbouchez/palm/stream"
+)
+
+type StreamChunk struct {
+	ID      string          `json:"id"`
+	Object  string          `json:"object"`
+	Created int64           `json:"created"`
+	Model   string          `json:"model"`
+	Choices []StreamChoice  `json:"choices"`
+	Usage   *provider.Usage `json:"usage,omitempty"`
+}
+
+type StreamChoice struct {
+	Index        int         `json:"index"`
+	Delta        StreamDelta `json:"delta"`
+	FinishReason *string     `json:"finish_reason"`
+}
+
+// StreamDelta keeps Content raw because vendors send either a string or a
+// list of typed chunks.
+type StreamDelta struct {
+	Role      string           `json:"role,omitempty"`
+	Content   json.RawMessage  `json:"content,omitempty"`
+	ToolCalls []streamToolCall `json:"tool_calls,omitempty"`
+}
+
+type streamToolCall struct {
+	Index    int             `json:"index"`
+	ID       string          `json:"id,omitempty"`
+	Type     string          `json:"type,omitempty"`
+	Function *streamFunction `json:"function,omitempty"`
+}
+
+type streamFunction struct {
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments,omitempty"`
+}
+
+// ContentFunc splits the content of a delta into reasoning and answer text.
+type ContentFunc func(raw json.RawMessage, parser *provider.ThinkTagParser) []provider.Segment
+
+// ThinkTagContent reads content as a string that may wrap reasoning in
+// <think> tags, as served by self-hosted reasoning models.
+func ThinkTagContent(raw json.RawMessage, parser *provider.ThinkTagParser) []provider.Segment {
+	var text string
+	if err := json.Unmarshal(raw, &text); err != nil || text == "" {
+		return nil
+	}
+	return parser.Feed(text)
+}
+
+func generateID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ReadStream turns a streamed chat completion into a complete UI message
+// stream on writer and returns the assembled reply.
+func ReadStream(body io.Reader, writer io.Writer, content ContentFunc) (*provider.StreamResult, error) {
+	emitter := stream.NewEmitter(writer)
+	emitter.Start(generateID())
+
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	var toolCalls []*accumulatedToolCall
+	byIndex := make(map[int]*accumulatedToolCall)
+	textID := ""
+	textEnded := false
+	reasoningID := ""
+	finished := false
+	var text, reasoning strings.Builder
+	var thinkParser provider.ThinkTagParser
+	var usage provider.Usage
+	var finishReason provider.FinishReason
+
+	endReasoning := func() {
+		if reasoningID != "" {
+			emitter.ReasoningEnd(reasoningID)
+			reasoningID = ""
+		}
+	}
+	endText := func() {
+		endReasoning()
+		if textID != "" && !textEnded {
+			emitter.TextEnd(textID)
+			textEnded = true
+		}
+	}
+	writeSegments := func(segments []provider.Segment) {
+		for _, seg := range segments {
+			if seg.Reasoning {
+				if reasoningID == "" {
+					endText()
+					reasoningID = generateID()
+					emitter.ReasoningStart(reasoningID)
+				}
+				emitter.ReasoningDelta(reasoningID, seg.Text)
+				reasoning.WriteString(seg.Text)
+				continue
+			}
+
+			endReasoning()
+			if textID == "" || textEnded {
+				textID = generateID()
+				emitter.TextStart(textID)
+				textEnded = false
+			}
+			emitter.TextDelta(textID, seg.Text)
+			text.WriteString(seg.Text)
+		}
+	}
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "[DONE]" {
+			break
+		}
+
+		var chunk StreamChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			continue
+		}
+
+		if chunk.Usage != nil {
+			usage = *chunk.Usage
+		}
+
+		if len(chunk.Choices) == 0 {
+			continue
+		}
+
+		choice := chunk.Choices[0]
+
+		if segments := content(choice.Delta.Content, &thinkParser); len(segments) > 0 {
+			// Text after a tool call means the call is complete.
+			if n := len(toolCalls); n > 0 {
+				toolCalls[n-1].emitAvailable(emitter)
+			}
+			writeSegments(segments)
+		}
+
+		for _, tc := range choice.Delta.ToolCalls {
+			acc, exists := byIndex[tc.Index]
+			if !exists {
+				// Calls are streamed one after the other, so a new index
+				// means the text and the previous call are complete.
+				endText()
+				if n := len(toolCalls); n > 0 {
+					toolCalls[n-1].emitAvailable(emitter)
+				}
+
+				acc = &accumulatedToolCall{Type: "function"}
+				byIndex[tc.Index] = acc
+				toolCalls = append(toolCalls, acc)
+			}
+
+			if tc.ID != "" {
+				acc.ID = tc.ID
+			}
+			if tc.Type != "" {
+				acc.Type = tc.Type
+			}
+			if tc.Function != nil {
+				if tc.Function.Name != "" && acc.Name == "" {
+					// Some OpenAI-compatible servers never send a call ID.
+					if acc.ID == "" {
+						acc.ID = "call_" + generateID()
+					}
+					acc.Name = tc.Function.Name
+					emitter.ToolInputStart(acc.ID, acc.Name)
+				}
+				if tc.Function.Arguments != "" {
+					acc.Arguments += tc.Function.Arguments
+					emitter.ToolInputDelta(acc.ID, tc.Function.Arguments)
+				}
+			}
+		}
+
+		if choice.FinishReason != nil && !finished {
+			finished = true
+			finishReason = toFinishReason(*choice.FinishReason)
+			writeSegments(thinkParser.Flush())
+			endText()
+			if n := len(toolCalls); n > 0 {
+				toolCalls[n-1].emitAvailable(emitter)
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("scan stream: %w", err)
+	}
+
+	emitter.Finish(finishReason.StreamReason(), map[string]any{"usage": usage})
+	emitter.Done()
+
+	result := &provider.StreamResult{
+		Usage:        usage,
+		FinishReason: finishReason,
+		Message: provider.Message{
+			Role:      "assistant",
+			Content:   text.String(),
+			Reasoning: reasoning.String(),
+		},
+	}
+
+	if len(toolCalls) > 0 {
+		result.Message.ToolCalls = make([]provider.ToolCall, 0, len(toolCalls))
+		for _, acc := range toolCalls {
+			result.Message.ToolCalls = append(result.Message.ToolCalls, provider.ToolCall{
+				ID:   acc.ID,
+				Type: acc.Type,
+				Function: provider.FunctionCall{
+					Name:      acc.Name,
+					Arguments: acc.Arguments,
+				},
+			})
+		}
+	}
+
+	return result, nil
+}
+
+// toFinishReason maps the legacy function_call reason that some servers
+// still send.
+func toFinishReason(reason string) provider.FinishReason {
+	if reason == "function_call" {
+		return provider.FinishReasonToolCalls
+	}
+	return provider.FinishReason(reason)
+}
+
+type accumulatedToolCall struct {
+	ID        string
+	Type      string
+	Name      string
+	Arguments string
+	available bool
+}
+
+func (acc *accumulatedToolCall) emitAvailable(emitter *stream.Emitter) {
+	if acc.available {
+		return
+	}
+	acc.available = true
+
+	var inputJSON any
+	if err := json.Unmarshal([]byte(acc.Arguments), &inputJSON); err != nil {
+		slog.Warn("failed to parse tool input as JSON", "error", err, "args", acc.Arguments)
+		inputJSON = acc.Arguments
+	}
+	emitter.ToolInputAvailable(acc.ID, acc.Name, inputJSON)
+}
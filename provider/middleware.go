@@ -0,0 +1,317 @@
+package provider
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+// Middleware wraps a provider to add behavior around its calls. Wrapped
+// providers forward ModelLister to the provider underneath; other optional
+// interfaces are reached with Unwrap. Embedders and completers are separate
+// clients, so middleware never stands between a caller and them.
+type Middleware func(Provider) Provider
+
+// Chain wraps p in middleware. The first middleware is the outermost, so it
+// sees each call first and its result last.
+func Chain(p Provider, middleware ...Middleware) Provider {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		p = middleware[i](p)
+	}
+	return p
+}
+
+type ChatFunc func(ctx context.Context, messages []Message, tools []Tool) (*ChatResponse, error)
+
+type StreamFunc func(ctx context.Context, messages []Message, tools []Tool, writer io.Writer) (*StreamResult, error)
+
+// Wrap returns a provider that forwards configuration to next and serves
+// calls with chat and stream. Either may be nil to call next directly.
+func Wrap(next Provider, chat ChatFunc, stream StreamFunc) Provider {
+	if chat == nil {
+		chat = next.ChatContext
+	}
+	if stream == nil {
+		stream = next.StreamChatContext
+	}
+	return &wrapped{next: next, chat: chat, stream: stream}
+}
+
+type wrapped struct {
+	next   Provider
+	chat   ChatFunc
+	stream StreamFunc
+}
+
+func (w *wrapped) WithAPIKey(key string) Provider {
+	w.next.WithAPIKey(key)
+	return w
+}
+
+func (w *wrapped) WithModel(model string) Provider {
+	w.next.WithModel(model)
+	return w
+}
+
+func (w *wrapped) WithBaseURL(url string) Provider {
+	w.next.WithBaseURL(url)
+	return w
+}
+
+func (w *wrapped) WithRetryPolicy(policy RetryPolicy) Provider {
+	w.next.WithRetryPolicy(policy)
+	return w
+}
+
+func (w *wrapped) WithHTTPClient(client *http.Client) Provider {
+	w.next.WithHTTPClient(client)
+	return w
+}
+
+func (w *wrapped) WithGenerationOptions(opts GenerationOptions) Provider {
+	w.next.WithGenerationOptions(opts)
+	return w
+}
+
+// Unwrap returns the provider w wraps.
+func (w *wrapped) Unwrap() Provider {
+	return w.next
+}
+
+// Unwrap peels all middleware off p and returns the provider underneath.
+func Unwrap(p Provider) Provider {
+	for {
+		u, ok := p.(interface{ Unwrap() Provider })
+		if !ok {
+			return p
+		}
+		p = u.Unwrap()
+	}
+}
+
+// ListModels and DescribeModel pass through to next so that wrapping a
+// provider does not hide its model catalog.
+func (w *wrapped) ListModels(ctx context.Context) ([]ModelInfo, error) {
+	lister, ok := w.next.(ModelLister)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	return lister.ListModels(ctx)
+}
+
+func (w *wrapped) DescribeModel(ctx context.Context, id string) (*ModelInfo, error) {
+	lister, ok := w.next.(ModelLister)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	return lister.DescribeModel(ctx, id)
+}
+
+func (w *wrapped) Chat(messages []Message, tools []Tool) (*ChatResponse, error) {
+	return w.chat(context.Background(), messages, tools)
+}
+
+func (w *wrapped) ChatContext(ctx context.Context, messages []Message, tools []Tool) (*ChatResponse, error) {
+	return w.chat(ctx, messages, tools)
+}
+
+func (w *wrapped) StreamChat(messages []Message, tools []Tool, writer io.Writer) (*StreamResult, error) {
+	return w.stream(context.Background(), messages, tools, writer)
+}
+
+func (w *wrapped) StreamChatContext(ctx context.Context, messages []Message, tools []Tool, writer io.Writer) (*StreamResult, error) {
+	return w.stream(ctx, messages, tools, writer)
+}
+
+// CallInfo describes a finished provider call.
+type CallInfo struct {
+	Streaming    bool
+	Messages     []Message
+	Tools        []Tool
+	Message      Message
+	Usage        Usage
+	FinishReason FinishReason
+	Duration     time.Duration
+	Err          error
+}
+
+// observe calls before ahead of every call, which it may reject, and after
+// once the call has finished.
+func observe(next Provider, before func(ctx context.Context, messages []Message, tools []Tool) error, after func(ctx context.Context, info CallInfo)) Provider {
+	return Wrap(next,
+		func(ctx context.Context, messages []Message, tools []Tool) (*ChatResponse, error) {
+			if before != nil {
+				if err := before(ctx, messages, tools); err != nil {
+					return nil, err
+				}
+			}
+			start := time.Now()
+			resp, err := next.ChatContext(ctx, messages, tools)
+			info := CallInfo{Messages: messages, Tools: tools, Duration: time.Since(start), Err: err}
+			if resp != nil {
+				info.Usage = resp.Usage
+				if len(resp.Choices) > 0 {
+					info.Message = resp.Choices[0].Message
+					info.FinishReason = resp.Choices[0].FinishReason
+				}
+			}
+			if after != nil {
+				after(ctx, info)
+			}
+			return resp, err
+		},
+		func(ctx context.Context, messages []Message, tools []Tool, writer io.Writer) (*StreamResult, error) {
+			if before != nil {
+				if err := before(ctx, messages, tools); err != nil {
+					return nil, err
+				}
+			}
+			start := time.Now()
+			result, err := next.StreamChatContext(ctx, messages, tools, writer)
+			info := CallInfo{Streaming: true, Messages: messages, Tools: tools, Duration: time.Since(start), Err: err}
+			if result != nil {
+				info.Message = result.Message
+				info.Usage = result.Usage
+				info.FinishReason = result.FinishReason
+			}
+			if after != nil {
+				after(ctx, info)
+			}
+			return result, err
+		},
+	)
+}
+
+// Logging logs every call with its duration, token usage and outcome.
+// Message content is not logged.
+func Logging(logger *slog.Logger) Middleware {
+	return func(next Provider) Provider {
+		return observe(next,
+			func(ctx context.Context, messages []Message, tools []Tool) error {
+				logger.InfoContext(ctx, "provider request", "messages", len(messages), "tools", len(tools))
+				return nil
+			},
+			func(ctx context.Context, info CallInfo) {
+				if info.Err != nil {
+					logger.ErrorContext(ctx, "provider request failed", "duration", info.Duration, "error", info.Err)
+					return
+				}
+				logger.InfoContext(ctx, "provider response",
+					"duration", info.Duration,
+					"finish_reason", info.FinishReason,
+					"prompt_tokens", info.Usage.PromptTokens,
+					"completion_tokens", info.Usage.CompletionTokens,
+					"tool_calls", len(info.Message.ToolCalls),
+				)
+			},
+		)
+	}
+}
+
+// MetricsRecorder receives one CallInfo per finished call, for example to
+// update counters and latency histograms.
+type MetricsRecorder interface {
+	RecordCall(ctx context.Context, info CallInfo)
+}
+
+type MetricsRecorderFunc func(ctx context.Context, info CallInfo)
+
+func (f MetricsRecorderFunc) RecordCall(ctx context.Context, info CallInfo) {
+	f(ctx, info)
+}
+
+func Metrics(recorder MetricsRecorder) Middleware {
+	return func(next Provider) Provider {
+		return observe(next, nil, recorder.RecordCall)
+	}
+}
+
+// Hooks are called around every call. OnRequest may reject the call by
+// returning an error; OnResponse sees the outcome, including failures.
+type Hooks struct {
+	OnRequest  func(ctx context.Context, messages []Message, tools []Tool) error
+	OnResponse func(ctx context.Context, info CallInfo)
+}
+
+func WithHooks(hooks Hooks) Middleware {
+	return func(next Provider) Provider {
+		return observe(next, hooks.OnRequest, hooks.OnResponse)
+	}
+}
+
+type headersKey struct{}
+
+// ContextWithHeaders adds HTTP headers to the requests providers send with
+// the returned context, on top of any already present in ctx.
+func ContextWithHeaders(ctx context.Context, headers http.Header) context.Context {
+	merged := HeadersFromContext(ctx).Clone()
+	if merged == nil {
+		merged = http.Header{}
+	}
+	for key, values := range headers {
+		merged[http.CanonicalHeaderKey(key)] = values
+	}
+	return context.WithValue(ctx, headersKey{}, merged)
+}
+
+func HeadersFromContext(ctx context.Context) http.Header {
+	headers, _ := ctx.Value(headersKey{}).(http.Header)
+	return headers
+}
+
+// Headers adds headers to every HTTP request the provider sends, such as
+// the ones an API gateway expects.
+func Headers(headers http.Header) Middleware {
+	return func(next Provider) Provider {
+		return Wrap(next,
+			func(ctx context.Context, messages []Message, tools []Tool) (*ChatResponse, error) {
+				return next.ChatContext(ContextWithHeaders(ctx, headers), messages, tools)
+			},
+			func(ctx context.Context, messages []Message, tools []Tool, writer io.Writer) (*StreamResult, error) {
+				return next.StreamChatContext(ContextWithHeaders(ctx, headers), messages, tools, writer)
+			},
+		)
+	}
+}
+
+// Redact replaces every match of patterns in the text sent to the provider
+// with replacement. The caller's messages are left untouched.
+func Redact(replacement string, patterns ...*regexp.Regexp) Middleware {
+	redact := func(s string) string {
+		for _, re := range patterns {
+			s = re.ReplaceAllString(s, replacement)
+		}
+		return s
+	}
+
+	redactMessages := func(messages []Message) []Message {
+		out := make([]Message, len(messages))
+		for i, msg := range messages {
+			msg.Content = redact(msg.Content)
+			if len(msg.Parts) > 0 {
+				parts := make([]ContentPart, len(msg.Parts))
+				for j, p := range msg.Parts {
+					p.Text = redact(p.Text)
+					parts[j] = p
+				}
+				msg.Parts = parts
+			}
+			out[i] = msg
+		}
+		return out
+	}
+
+	return func(next Provider) Provider {
+		return Wrap(next,
+			func(ctx context.Context, messages []Message, tools []Tool) (*ChatResponse, error) {
+				return next.ChatContext(ctx, redactMessages(messages), tools)
+			},
+			func(ctx context.Context, messages []Message, tools []Tool, writer io.Writer) (*StreamResult, error) {
+				return next.StreamChatContext(ctx, redactMessages(messages), tools, writer)
+			},
+		)
+	}
+}
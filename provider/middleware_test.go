@@ -0,0 +1,238 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"sync"
+	"testing"
+)
+
+// stub answers every call with message and usage, or fails with err, and
+// records the messages it was sent.
+type stub struct {
+	Provider
+	message Message
+	usage   Usage
+	err     error
+
+	calls [][]Message
+}
+
+func (s *stub) ChatContext(ctx context.Context, messages []Message, tools []Tool) (*ChatResponse, error) {
+	s.calls = append(s.calls, messages)
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &ChatResponse{Choices: []Choice{{Message: s.message, FinishReason: FinishReasonStop}}, Usage: s.usage}, nil
+}
+
+func (s *stub) StreamChatContext(ctx context.Context, messages []Message, tools []Tool, writer io.Writer) (*StreamResult, error) {
+	s.calls = append(s.calls, messages)
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &StreamResult{Message: s.message, Usage: s.usage, FinishReason: FinishReasonStop}, nil
+}
+
+func userMessages(text string) []Message {
+	return []Message{{Role: "user", Content: text}}
+}
+
+func TestChainOrder(t *testing.T) {
+	var order []string
+	trace := func(name string) Middleware {
+		return func(next Provider) Provider {
+			return Wrap(next, func(ctx context.Context, messages []Message, tools []Tool) (*ChatResponse, error) {
+				order = append(order, name+" before")
+				resp, err := next.ChatContext(ctx, messages, tools)
+				order = append(order, name+" after")
+				return resp, err
+			}, nil)
+		}
+	}
+
+	p := Chain(&stub{}, trace("outer"), trace("inner"))
+	if _, err := p.ChatContext(context.Background(), userMessages("hi"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"outer before", "inner before", "inner after", "outer after"}
+	if !reflect.DeepEqual(order, want) {
+		t.Errorf("order = %v, want %v", order, want)
+	}
+}
+
+// retrying sends one request through RetryPolicy.Do per call, the way the
+// HTTP providers do.
+type retrying struct {
+	Provider
+	url string
+}
+
+func (r retrying) ChatContext(ctx context.Context, messages []Message, tools []Tool) (*ChatResponse, error) {
+	resp, err := fastRetry.Do(ctx, http.DefaultClient, get(ctx, r.url))
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return &ChatResponse{}, nil
+}
+
+func TestHeadersOnEveryAttempt(t *testing.T) {
+	var mu sync.Mutex
+	var seen []string
+	attempts := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		seen = append(seen, r.Header.Get("X-Gateway-Key"))
+		if attempts++; attempts < 3 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	p := Chain(retrying{url: srv.URL}, Headers(http.Header{"X-Gateway-Key": {"k1"}}))
+	if _, err := p.ChatContext(context.Background(), userMessages("hi"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"k1", "k1", "k1"}; !reflect.DeepEqual(seen, want) {
+		t.Errorf("header on each attempt = %q, want %q", seen, want)
+	}
+}
+
+func TestRedact(t *testing.T) {
+	inner := &stub{}
+	p := Chain(inner, Redact("[email]", regexp.MustCompile(`[\w.]+@\w+\.\w+`)))
+
+	messages := []Message{
+		{Role: "user", Content: "mail ada@example.com"},
+		{Role: "user", Parts: []ContentPart{TextPart("cc bob@example.org"), ImageURLPart("https://example.com/a@b.png")}},
+	}
+	if _, err := p.StreamChatContext(context.Background(), messages, nil, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	sent := inner.calls[0]
+	if sent[0].Content != "mail [email]" || sent[1].Parts[0].Text != "cc [email]" {
+		t.Errorf("sent %+v, want addresses redacted", sent)
+	}
+	if sent[1].Parts[1].URL != "https://example.com/a@b.png" {
+		t.Errorf("image URL changed to %q", sent[1].Parts[1].URL)
+	}
+	if messages[0].Content != "mail ada@example.com" || messages[1].Parts[0].Text != "cc bob@example.org" {
+		t.Errorf("caller's messages were modified: %+v", messages)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	inner := &stub{message: Message{Role: "assistant", Content: "hello"}, usage: Usage{PromptTokens: 3, CompletionTokens: 2, TotalTokens: 5}}
+
+	var calls []CallInfo
+	p := Chain(inner, Metrics(MetricsRecorderFunc(func(ctx context.Context, info CallInfo) {
+		calls = append(calls, info)
+	})))
+
+	if _, err := p.ChatContext(context.Background(), userMessages("hi"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.StreamChatContext(context.Background(), userMessages("hi"), nil, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	inner.err = errors.New("boom")
+	p.ChatContext(context.Background(), userMessages("hi"), nil)
+
+	if len(calls) != 3 {
+		t.Fatalf("recorded %d calls, want 3", len(calls))
+	}
+	for i, info := range calls[:2] {
+		if info.Message.Content != "hello" || info.Usage.TotalTokens != 5 || info.FinishReason != FinishReasonStop || info.Err != nil {
+			t.Errorf("call %d: %+v", i, info)
+		}
+		if info.Streaming != (i == 1) {
+			t.Errorf("call %d: Streaming = %v", i, info.Streaming)
+		}
+	}
+	if calls[2].Err == nil {
+		t.Error("failed call recorded without its error")
+	}
+}
+
+func TestHooks(t *testing.T) {
+	inner := &stub{message: Message{Role: "assistant", Content: "hello"}}
+	rejected := errors.New("over budget")
+
+	var responses []CallInfo
+	reject := false
+	p := Chain(inner, WithHooks(Hooks{
+		OnRequest: func(ctx context.Context, messages []Message, tools []Tool) error {
+			if reject {
+				return rejected
+			}
+			return nil
+		},
+		OnResponse: func(ctx context.Context, info CallInfo) {
+			responses = append(responses, info)
+		},
+	}))
+
+	if _, err := p.StreamChatContext(context.Background(), userMessages("hi"), nil, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if len(responses) != 1 || responses[0].Message.Content != "hello" {
+		t.Errorf("OnResponse got %+v", responses)
+	}
+
+	reject = true
+	if _, err := p.StreamChatContext(context.Background(), userMessages("hi"), nil, io.Discard); !errors.Is(err, rejected) {
+		t.Errorf("err = %v, want the OnRequest error", err)
+	}
+	if len(inner.calls) != 1 {
+		t.Errorf("provider called %d times, want 1: OnRequest should stop the call", len(inner.calls))
+	}
+	if len(responses) != 1 {
+		t.Errorf("OnResponse called for a rejected call")
+	}
+}
+
+// lister is a provider that describes its models.
+type lister struct {
+	stub
+}
+
+func (lister) ListModels(ctx context.Context) ([]ModelInfo, error) {
+	return []ModelInfo{{ID: "small"}}, nil
+}
+
+func (lister) DescribeModel(ctx context.Context, id string) (*ModelInfo, error) {
+	return &ModelInfo{ID: "small"}, nil
+}
+
+func TestWrappedForwardsModelLister(t *testing.T) {
+	inner := &lister{}
+	p := Chain(inner, Logging(discardLogger()), Metrics(MetricsRecorderFunc(func(context.Context, CallInfo) {})))
+
+	models, err := p.(ModelLister).ListModels(context.Background())
+	if err != nil || len(models) != 1 || models[0].ID != "small" {
+		t.Errorf("ListModels = %+v, %v", models, err)
+	}
+	if Unwrap(p) != Provider(inner) {
+		t.Errorf("Unwrap = %T, want the innermost provider", Unwrap(p))
+	}
+
+	plain := Chain(&stub{}, Logging(discardLogger()))
+	if _, err := plain.(ModelLister).ListModels(context.Background()); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("err = %v, want ErrNotSupported", err)
+	}
+}
+
+func discardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
@@ -0,0 +1,18 @@
+package mistral_test
+
+import (
+	"testing"
+
+	"github.com/alexisbouchez/palm/provider"
+	"github.com/alexisbouchez/palm/provider/mistral"
+	"github.com/alexisbouchez/palm/provider/providertest"
+)
+
+func TestConformance(t *testing.T) {
+	providertest.RunConformance(t, providertest.Factory{
+		Wire: providertest.OpenAIWire,
+		New: func(baseURL string) provider.Provider {
+			return mistral.New().WithAPIKey("test").WithBaseURL(baseURL)
+		},
+	})
+}
@@ -0,0 +1,155 @@
+package mistral
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync/atomic"
+
+	"github.com/alexisbouchez/palm/provider"
+)
+
+const defaultBatchSize = 128
+
+// modelDimensions lists the default vector size of the embedding models.
+var modelDimensions = map[string]int{
+	"mistral-embed":   1024,
+	"codestral-embed": 1536,
+}
+
+type embedder struct {
+	client     *mistral
+	batchSize  int
+	dimensions int
+	observed   atomic.Int64
+}
+
+func NewEmbedder() provider.Embedder {
+	return &embedder{
+		client: &mistral{
+			model:      "mistral-embed",
+			baseURL:    baseURL,
+			retry:      provider.DefaultRetryPolicy(),
+			httpClient: http.DefaultClient,
+		},
+		batchSize: defaultBatchSize,
+	}
+}
+
+func (e *embedder) WithAPIKey(key string) provider.Embedder {
+	e.client.apiKey = key
+	return e
+}
+
+func (e *embedder) WithModel(model string) provider.Embedder {
+	e.client.model = model
+	return e
+}
+
+func (e *embedder) WithBaseURL(url string) provider.Embedder {
+	e.client.baseURL = url
+	return e
+}
+
+func (e *embedder) WithRetryPolicy(policy provider.RetryPolicy) provider.Embedder {
+	e.client.retry = policy
+	return e
+}
+
+func (e *embedder) WithHTTPClient(client *http.Client) provider.Embedder {
+	e.client.httpClient = client
+	return e
+}
+
+func (e *embedder) WithBatchSize(n int) provider.Embedder {
+	e.batchSize = n
+	return e
+}
+
+// WithDimensions requests vectors of n dimensions. Only models that support
+// output_dimension, such as codestral-embed, accept it.
+func (e *embedder) WithDimensions(n int) provider.Embedder {
+	e.dimensions = n
+	return e
+}
+
+func (e *embedder) Dimensions() int {
+	if e.dimensions > 0 {
+		return e.dimensions
+	}
+	if d, ok := modelDimensions[e.client.model]; ok {
+		return d
+	}
+	return int(e.observed.Load())
+}
+
+type embeddingRequest struct {
+	Model           string   `json:"model"`
+	Input           []string `json:"input"`
+	OutputDimension *int     `json:"output_dimension,omitempty"`
+}
+
+type embeddingResponse struct {
+	Data []struct {
+		Index     int       `json:"index"`
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
+}
+
+func (e *embedder) Embed(ctx context.Context, inputs []string) ([][]float32, error) {
+	batchSize := e.batchSize
+	if batchSize <= 0 {
+		batchSize = defaultBatchSize
+	}
+
+	embeddings := make([][]float32, 0, len(inputs))
+	for start := 0; start < len(inputs); start += batchSize {
+		end := min(start+batchSize, len(inputs))
+		batch, err := e.embedBatch(ctx, inputs[start:end])
+		if err != nil {
+			return nil, fmt.Errorf("embed inputs %d-%d: %w", start, end-1, err)
+		}
+		embeddings = append(embeddings, batch...)
+	}
+
+	return embeddings, nil
+}
+
+func (e *embedder) embedBatch(ctx context.Context, inputs []string) ([][]float32, error) {
+	req := embeddingRequest{
+		Model: e.client.model,
+		Input: inputs,
+	}
+	if e.dimensions > 0 {
+		req.OutputDimension = &e.dimensions
+	}
+
+	resp, err := e.client.post(ctx, "/embeddings", req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var embResp embeddingResponse
+	if err := json.NewDecoder(resp.Body).Decode(&embResp); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+	if len(embResp.Data) != len(inputs) {
+		return nil, fmt.Errorf("got %d embeddings for %d inputs", len(embResp.Data), len(inputs))
+	}
+
+	sort.Slice(embResp.Data, func(i, j int) bool {
+		return embResp.Data[i].Index < embResp.Data[j].Index
+	})
+
+	embeddings := make([][]float32, len(embResp.Data))
+	for i, d := range embResp.Data {
+		embeddings[i] = d.Embedding
+	}
+	if len(embeddings) > 0 {
+		e.observed.Store(int64(len(embeddings[0])))
+	}
+	return embeddings, nil
+}
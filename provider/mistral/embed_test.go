@@ -0,0 +1,108 @@
+package mistral
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/alexisbouchez/palm/provider"
+)
+
+// embeddingServer embeds each input "n" as the vector [n, n] and returns the
+// batch in reverse order. It records the size of every batch.
+func embeddingServer(t *testing.T) (*httptest.Server, func() []int) {
+	t.Helper()
+
+	var mu sync.Mutex
+	var batches []int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/embeddings" {
+			t.Errorf("path = %q", r.URL.Path)
+		}
+		var req embeddingRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decode request: %v", err)
+		}
+		mu.Lock()
+		batches = append(batches, len(req.Input))
+		mu.Unlock()
+
+		type datum struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		}
+		var data []datum
+		for i, input := range slices.Backward(req.Input) {
+			n, _ := strconv.Atoi(input)
+			data = append(data, datum{Index: i, Embedding: []float32{float32(n), float32(n)}})
+		}
+		json.NewEncoder(w).Encode(map[string]any{"data": data})
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, func() []int {
+		mu.Lock()
+		defer mu.Unlock()
+		return slices.Clone(batches)
+	}
+}
+
+func TestEmbedBatchesAndOrders(t *testing.T) {
+	srv, batches := embeddingServer(t)
+	e := NewEmbedder().WithBaseURL(srv.URL).WithModel("custom-embed").WithBatchSize(2)
+
+	if d := e.Dimensions(); d != 0 {
+		t.Errorf("Dimensions before the first call = %d, want 0", d)
+	}
+
+	inputs := []string{"0", "1", "2", "3", "4"}
+	vectors, err := e.Embed(context.Background(), inputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := batches(); !slices.Equal(got, []int{2, 2, 1}) {
+		t.Errorf("batch sizes = %v, want [2 2 1]", got)
+	}
+	if len(vectors) != len(inputs) {
+		t.Fatalf("got %d vectors for %d inputs", len(vectors), len(inputs))
+	}
+	for i, v := range vectors {
+		if v[0] != float32(i) {
+			t.Errorf("vector %d = %v, want the embedding of input %d", i, v, i)
+		}
+	}
+	if d := e.Dimensions(); d != 2 {
+		t.Errorf("Dimensions = %d, want 2 from the response", d)
+	}
+}
+
+func TestEmbedDimensions(t *testing.T) {
+	if d := NewEmbedder().Dimensions(); d != 1024 {
+		t.Errorf("mistral-embed Dimensions = %d, want 1024", d)
+	}
+	if d := NewEmbedder().WithModel("codestral-embed").WithDimensions(256).Dimensions(); d != 256 {
+		t.Errorf("Dimensions = %d, want the requested 256", d)
+	}
+}
+
+func TestEmbedConcurrently(t *testing.T) {
+	srv, _ := embeddingServer(t)
+	e := NewEmbedder().WithBaseURL(srv.URL).WithModel("custom-embed").WithRetryPolicy(provider.RetryPolicy{MaxAttempts: 1})
+
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Go(func() {
+			if _, err := e.Embed(context.Background(), []string{"1", "2"}); err != nil {
+				t.Error(err)
+			}
+			e.Dimensions()
+		})
+	}
+	wg.Wait()
+}
@@ -0,0 +1,165 @@
+package mistral
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/alexisbouchez/palm/provider"
+	"github.com/alexisbouchez/palm/provider/internal/chatcompletions"
+)
+
+type completer struct {
+	client *mistral
+}
+
+func NewCompleter() provider.Completer {
+	return &completer{
+		client: &mistral{
+			model:      "codestral-latest",
+			baseURL:    baseURL,
+			retry:      provider.DefaultRetryPolicy(),
+			httpClient: http.DefaultClient,
+		},
+	}
+}
+
+func (c *completer) WithAPIKey(key string) provider.Completer {
+	c.client.apiKey = key
+	return c
+}
+
+func (c *completer) WithModel(model string) provider.Completer {
+	c.client.model = model
+	return c
+}
+
+func (c *completer) WithBaseURL(url string) provider.Completer {
+	c.client.baseURL = url
+	return c
+}
+
+func (c *completer) WithRetryPolicy(policy provider.RetryPolicy) provider.Completer {
+	c.client.retry = policy
+	return c
+}
+
+func (c *completer) WithHTTPClient(client *http.Client) provider.Completer {
+	c.client.httpClient = client
+	return c
+}
+
+func (c *completer) WithGenerationOptions(opts provider.GenerationOptions) provider.Completer {
+	c.client.options = opts
+	return c
+}
+
+type fimRequest struct {
+	Model       string   `json:"model"`
+	Prompt      string   `json:"prompt"`
+	Suffix      string   `json:"suffix,omitempty"`
+	Stream      bool     `json:"stream,omitempty"`
+	Temperature *float64 `json:"temperature,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty"`
+	MaxTokens   *int     `json:"max_tokens,omitempty"`
+	Stop        []string `json:"stop,omitempty"`
+	RandomSeed  *int     `json:"random_seed,omitempty"`
+}
+
+func (c *completer) newFIMRequest(ctx context.Context, prefix, suffix string) fimRequest {
+	opts := c.client.options.Merge(provider.GenerationOptionsFromContext(ctx))
+	return fimRequest{
+		Model:       c.client.model,
+		Prompt:      prefix,
+		Suffix:      suffix,
+		Temperature: opts.Temperature,
+		TopP:        opts.TopP,
+		MaxTokens:   opts.MaxTokens,
+		Stop:        opts.Stop,
+		RandomSeed:  opts.RandomSeed,
+	}
+}
+
+func (c *completer) Complete(ctx context.Context, prefix, suffix string) (*provider.Completion, error) {
+	resp, err := c.client.post(ctx, "/fim/completions", c.newFIMRequest(ctx, prefix, suffix))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var chatResp provider.ChatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&chatResp); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+	if len(chatResp.Choices) == 0 {
+		return nil, fmt.Errorf("no choices in response")
+	}
+
+	choice := chatResp.Choices[0]
+	return &provider.Completion{
+		Text:         choice.Message.Content,
+		Usage:        chatResp.Usage,
+		FinishReason: choice.FinishReason,
+	}, nil
+}
+
+func (c *completer) StreamComplete(ctx context.Context, prefix, suffix string, writer io.Writer) (*provider.Completion, error) {
+	req := c.newFIMRequest(ctx, prefix, suffix)
+	req.Stream = true
+
+	resp, err := c.client.post(ctx, "/fim/completions", req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var text strings.Builder
+	completion := &provider.Completion{}
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		if data == "[DONE]" {
+			break
+		}
+
+		var chunk chatcompletions.StreamChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			continue
+		}
+
+		if chunk.Usage != nil {
+			completion.Usage = *chunk.Usage
+		}
+		if len(chunk.Choices) == 0 {
+			continue
+		}
+
+		choice := chunk.Choices[0]
+		var delta string
+		json.Unmarshal(choice.Delta.Content, &delta)
+		if delta != "" {
+			if _, err := io.WriteString(writer, delta); err != nil {
+				return nil, fmt.Errorf("write completion: %w", err)
+			}
+			text.WriteString(delta)
+		}
+		if choice.FinishReason != nil {
+			completion.FinishReason = provider.FinishReason(*choice.FinishReason)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("scan stream: %w", err)
+	}
+
+	completion.Text = text.String()
+	return completion, nil
+}
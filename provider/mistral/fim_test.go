@@ -0,0 +1,130 @@
+package mistral
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/alexisbouchez/palm/provider"
+)
+
+// fimServer answers /fim/completions with response and records the decoded
+// request body.
+func fimServer(t *testing.T, response string) (*httptest.Server, *map[string]any) {
+	t.Helper()
+
+	body := map[string]any{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/fim/completions" {
+			t.Errorf("path = %q, want /fim/completions", r.URL.Path)
+		}
+		data, _ := io.ReadAll(r.Body)
+		body = map[string]any{}
+		if err := json.Unmarshal(data, &body); err != nil {
+			t.Errorf("decode request: %v", err)
+		}
+		io.WriteString(w, response)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &body
+}
+
+func TestFIMRequest(t *testing.T) {
+	srv, body := fimServer(t, okResponse)
+	c := NewCompleter().WithBaseURL(srv.URL).WithGenerationOptions(provider.GenerationOptions{
+		Temperature: provider.Ptr(0.1),
+		MaxTokens:   provider.Ptr(64),
+	})
+
+	ctx := provider.ContextWithGenerationOptions(context.Background(), provider.GenerationOptions{
+		Stop:       []string{"\n\n"},
+		RandomSeed: provider.Ptr(7),
+	})
+	if _, err := c.Complete(ctx, "def add(a, b):\n", "\nprint(add(1, 2))"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]any{
+		"model":       "codestral-latest",
+		"prompt":      "def add(a, b):\n",
+		"suffix":      "\nprint(add(1, 2))",
+		"temperature": 0.1,
+		"max_tokens":  float64(64),
+		"stop":        []any{"\n\n"},
+		"random_seed": float64(7),
+	}
+	if !reflect.DeepEqual(*body, want) {
+		t.Errorf("request = %v\nwant %v", *body, want)
+	}
+
+	if _, err := c.Complete(context.Background(), "x = ", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := (*body)["suffix"]; ok {
+		t.Errorf("empty suffix sent: %v", *body)
+	}
+}
+
+func TestComplete(t *testing.T) {
+	srv, _ := fimServer(t, `{
+		"choices": [{"message": {"role": "assistant", "content": "    return a + b"}, "finish_reason": "stop"}],
+		"usage": {"prompt_tokens": 12, "completion_tokens": 6, "total_tokens": 18}
+	}`)
+
+	completion, err := NewCompleter().WithBaseURL(srv.URL).Complete(context.Background(), "def add(a, b):\n", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &provider.Completion{
+		Text:         "    return a + b",
+		Usage:        provider.Usage{PromptTokens: 12, CompletionTokens: 6, TotalTokens: 18},
+		FinishReason: provider.FinishReasonStop,
+	}
+	if !reflect.DeepEqual(completion, want) {
+		t.Errorf("completion = %+v, want %+v", completion, want)
+	}
+}
+
+func TestCompleteNoChoices(t *testing.T) {
+	srv, _ := fimServer(t, `{"choices": []}`)
+	if _, err := NewCompleter().WithBaseURL(srv.URL).Complete(context.Background(), "x", ""); err == nil {
+		t.Error("want an error for a response without choices")
+	}
+}
+
+func TestStreamComplete(t *testing.T) {
+	srv, body := fimServer(t, strings.Join([]string{
+		`data: {"choices":[{"index":0,"delta":{"role":"assistant","content":""}}]}`,
+		`data: {"choices":[{"index":0,"delta":{"content":"    return"}}]}`,
+		`: keep-alive`,
+		`data: {"choices":[{"index":0,"delta":{"content":" a + b"}}]}`,
+		`data: {"choices":[{"index":0,"delta":{"content":""},"finish_reason":"length"}],"usage":{"prompt_tokens":12,"completion_tokens":4,"total_tokens":16}}`,
+		`data: [DONE]`,
+	}, "\n\n")+"\n\n")
+
+	var out strings.Builder
+	completion, err := NewCompleter().WithBaseURL(srv.URL).StreamComplete(context.Background(), "def add(a, b):\n", "", &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if (*body)["stream"] != true {
+		t.Errorf("stream not requested: %v", *body)
+	}
+	if out.String() != "    return a + b" {
+		t.Errorf("streamed %q", out.String())
+	}
+	want := &provider.Completion{
+		Text:         "    return a + b",
+		Usage:        provider.Usage{PromptTokens: 12, CompletionTokens: 4, TotalTokens: 16},
+		FinishReason: provider.FinishReasonLength,
+	}
+	if !reflect.DeepEqual(completion, want) {
+		t.Errorf("completion = %+v, want %+v", completion, want)
+	}
+}
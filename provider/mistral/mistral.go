@@ -1,39 +1,41 @@
 package mistral
 
 import (
-	"bufio"
 	"bytes"
-	"crypto/rand"
-	"encoding/hex"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
-	"log/slog"
 	"net/http"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/alexisbouchez/palm/provider"
-	"github.com/alexisbouchez/palm/stream"
+	"github.com/alexisbouchez/palm/provider/internal/chatcompletions"
 )
 
 const baseURL = "https://api.mistral.ai/v1"
 
 type mistral struct {
-	apiKey  string
-	model   string
-	baseURL string
-}
-
-func generateID() string {
-	b := make([]byte, 8)
-	rand.Read(b)
-	return hex.EncodeToString(b)
+	apiKey     string
+	model      string
+	baseURL    string
+	retry      provider.RetryPolicy
+	httpClient *http.Client
+	options    provider.GenerationOptions
+
+	modelsMu      sync.Mutex
+	models        []provider.ModelInfo
+	modelsExpires time.Time
 }
 
 func New() provider.Provider {
 	return &mistral{
-		model:   "mistral-small-latest",
-		baseURL: baseURL,
+		model:      "mistral-small-latest",
+		baseURL:    baseURL,
+		retry:      provider.DefaultRetryPolicy(),
+		httpClient: http.DefaultClient,
 	}
 }
 
@@ -52,248 +54,189 @@ func (m *mistral) WithBaseURL(url string) provider.Provider {
 	return m
 }
 
-type chatRequest struct {
-	Model    string             `json:"model"`
-	Messages []provider.Message `json:"messages"`
-	Tools    []provider.Tool    `json:"tools,omitempty"`
-}
-
-func (m *mistral) Chat(messages []provider.Message, tools []provider.Tool) (*provider.ChatResponse, error) {
-	req := chatRequest{
-		Model:    m.model,
-		Messages: messages,
-		Tools:    tools,
-	}
-
-	body, err := json.Marshal(req)
-	if err != nil {
-		return nil, fmt.Errorf("marshal request: %w", err)
-	}
-
-	httpReq, err := http.NewRequest(http.MethodPost, m.baseURL+"/chat/completions", bytes.NewReader(body))
-	if err != nil {
-		return nil, fmt.Errorf("create request: %w", err)
-	}
-
-	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("Authorization", "Bearer "+m.apiKey)
-
-	resp, err := http.DefaultClient.Do(httpReq)
-	if err != nil {
-		return nil, fmt.Errorf("do request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		respBody, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("api error %d: %s", resp.StatusCode, string(respBody))
-	}
-
-	var chatResp provider.ChatResponse
-	if err := json.NewDecoder(resp.Body).Decode(&chatResp); err != nil {
-		return nil, fmt.Errorf("decode response: %w", err)
-	}
-
-	return &chatResp, nil
+func (m *mistral) WithRetryPolicy(policy provider.RetryPolicy) provider.Provider {
+	m.retry = policy
+	return m
 }
 
-type streamChunk struct {
-	ID      string         `json:"id"`
-	Object  string         `json:"object"`
-	Created int64          `json:"created"`
-	Model   string         `json:"model"`
-	Choices []streamChoice `json:"choices"`
+func (m *mistral) WithHTTPClient(client *http.Client) provider.Provider {
+	m.httpClient = client
+	return m
 }
 
-type streamChoice struct {
-	Index        int           `json:"index"`
-	Delta        streamDelta   `json:"delta"`
-	FinishReason *string       `json:"finish_reason"`
+func (m *mistral) WithGenerationOptions(opts provider.GenerationOptions) provider.Provider {
+	m.options = opts
+	return m
 }
 
-type streamDelta struct {
-	Role      string                `json:"role,omitempty"`
-	Content   string                `json:"content,omitempty"`
-	ToolCalls []streamToolCall      `json:"tool_calls,omitempty"`
+type chatRequest struct {
+	chatcompletions.Request
+	RandomSeed *int  `json:"random_seed,omitempty"`
+	SafePrompt *bool `json:"safe_prompt,omitempty"`
 }
 
-type streamToolCall struct {
-	Index    int                `json:"index"`
-	ID       string             `json:"id,omitempty"`
-	Type     string             `json:"type,omitempty"`
-	Function *streamFunction    `json:"function,omitempty"`
+type contentChunk struct {
+	Type         string `json:"type"`
+	Text         string `json:"text,omitempty"`
+	ImageURL     string `json:"image_url,omitempty"`
+	DocumentURL  string `json:"document_url,omitempty"`
+	DocumentName string `json:"document_name,omitempty"`
 }
 
-type streamFunction struct {
-	Name      string `json:"name,omitempty"`
-	Arguments string `json:"arguments,omitempty"`
+func toContentChunks(parts []provider.ContentPart) []contentChunk {
+	chunks := make([]contentChunk, 0, len(parts))
+	for _, p := range parts {
+		switch {
+		case p.Type == provider.ContentTypeText:
+			chunks = append(chunks, contentChunk{Type: "text", Text: p.Text})
+		case p.Type == provider.ContentTypeImageURL:
+			chunks = append(chunks, contentChunk{Type: "image_url", ImageURL: p.URL})
+		case p.Type == provider.ContentTypeDocumentURL:
+			chunks = append(chunks, contentChunk{Type: "document_url", DocumentURL: p.URL, DocumentName: p.Name})
+		case p.IsImage():
+			chunks = append(chunks, contentChunk{Type: "image_url", ImageURL: p.DataURL()})
+		case strings.HasPrefix(p.MediaType, "text/"):
+			chunks = append(chunks, contentChunk{Type: "text", Text: string(p.Data)})
+		default:
+			chunks = append(chunks, contentChunk{Type: "document_url", DocumentURL: p.DataURL(), DocumentName: p.Name})
+		}
+	}
+	return chunks
 }
 
-func (m *mistral) StreamChat(messages []provider.Message, tools []provider.Tool, writer io.Writer) (*provider.StreamResult, error) {
-	req := chatRequest{
-		Model:    m.model,
-		Messages: messages,
-		Tools:    tools,
+func (m *mistral) newChatRequest(ctx context.Context, messages []provider.Message, tools []provider.Tool) chatRequest {
+	opts := m.options.Merge(provider.GenerationOptionsFromContext(ctx))
+	return chatRequest{
+		Request:    chatcompletions.NewRequest(m.model, chatcompletions.ToMessages(messages, toContentChunks), tools, opts, "any"),
+		RandomSeed: opts.RandomSeed,
+		SafePrompt: opts.SafePrompt,
 	}
+}
 
-	body, err := json.Marshal(map[string]any{
-		"model":    req.Model,
-		"messages": req.Messages,
-		"tools":    req.Tools,
-		"stream":   true,
-	})
+func (m *mistral) post(ctx context.Context, path string, payload any) (*http.Response, error) {
+	body, err := json.Marshal(payload)
 	if err != nil {
 		return nil, fmt.Errorf("marshal request: %w", err)
 	}
 
-	httpReq, err := http.NewRequest(http.MethodPost, m.baseURL+"/chat/completions", bytes.NewReader(body))
-	if err != nil {
-		return nil, fmt.Errorf("create request: %w", err)
-	}
+	return m.retry.Do(ctx, m.httpClient, func() (*http.Request, error) {
+		httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, m.baseURL+path, bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("create request: %w", err)
+		}
+
+		httpReq.Header.Set("Content-Type", "application/json")
+		httpReq.Header.Set("Authorization", "Bearer "+m.apiKey)
+
+		return httpReq, nil
+	})
+}
+
+func (m *mistral) Chat(messages []provider.Message, tools []provider.Tool) (*provider.ChatResponse, error) {
+	return m.ChatContext(context.Background(), messages, tools)
+}
 
-	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("Authorization", "Bearer "+m.apiKey)
+type chatResponse struct {
+	ID      string `json:"id"`
+	Choices []struct {
+		Index   int `json:"index"`
+		Message struct {
+			Role      string              `json:"role"`
+			Content   json.RawMessage     `json:"content"`
+			ToolCalls []provider.ToolCall `json:"tool_calls"`
+		} `json:"message"`
+		FinishReason provider.FinishReason `json:"finish_reason"`
+	} `json:"choices"`
+	Usage provider.Usage `json:"usage"`
+}
 
-	slog.Info("sending request to mistral", "model", m.model)
-	resp, err := http.DefaultClient.Do(httpReq)
+func (m *mistral) ChatContext(ctx context.Context, messages []provider.Message, tools []provider.Tool) (*provider.ChatResponse, error) {
+	resp, err := m.post(ctx, "/chat/completions", m.newChatRequest(ctx, messages, tools))
 	if err != nil {
-		slog.Error("mistral request failed", "error", err)
-		return nil, fmt.Errorf("do request: %w", err)
+		return nil, err
 	}
 	defer resp.Body.Close()
 
-	slog.Info("received response from mistral", "status", resp.StatusCode)
-
-	if resp.StatusCode != http.StatusOK {
-		respBody, _ := io.ReadAll(resp.Body)
-		slog.Error("mistral api error", "status", resp.StatusCode, "body", string(respBody))
-		return nil, fmt.Errorf("api error %d: %s", resp.StatusCode, string(respBody))
+	var chatResp chatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&chatResp); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
 	}
 
-	emitter := stream.NewEmitter(writer)
-
-	messageID := generateID()
-	textID := generateID()
-
-	emitter.Start(messageID)
-
-	scanner := bufio.NewScanner(resp.Body)
-	toolCalls := make(map[int]*accumulatedToolCall)
-	textStarted := false
-	fullContent := ""
-	chunkCount := 0
-
-	for scanner.Scan() {
-		chunkCount++
-		line := scanner.Text()
-		if !strings.HasPrefix(line, "data: ") {
-			continue
-		}
-
-		data := strings.TrimPrefix(line, "data: ")
-		if data == "[DONE]" {
-			break
-		}
-
-		var chunk streamChunk
-		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
-			continue
-		}
-
-		if len(chunk.Choices) == 0 {
-			continue
-		}
+	result := &provider.ChatResponse{ID: chatResp.ID, Usage: chatResp.Usage}
+	for _, c := range chatResp.Choices {
+		msg := provider.Message{Role: c.Message.Role, ToolCalls: c.Message.ToolCalls}
 
-		choice := chunk.Choices[0]
-
-		if choice.Delta.Content != "" {
-			if !textStarted {
-				emitter.TextStart(textID)
-				textStarted = true
+		var parser provider.ThinkTagParser
+		segments := append(contentSegments(c.Message.Content, &parser), parser.Flush()...)
+		for _, seg := range segments {
+			if seg.Reasoning {
+				msg.Reasoning += seg.Text
+			} else {
+				msg.Content += seg.Text
 			}
-			emitter.TextDelta(textID, choice.Delta.Content)
-			fullContent += choice.Delta.Content
 		}
 
-		for _, tc := range choice.Delta.ToolCalls {
-			acc, exists := toolCalls[tc.Index]
-			if !exists {
-				acc = &accumulatedToolCall{}
-				toolCalls[tc.Index] = acc
-			}
-
-			if tc.ID != "" {
-				acc.ID = tc.ID
-			}
-			if tc.Type != "" {
-				acc.Type = tc.Type
-			}
-			if tc.Function != nil {
-				if tc.Function.Name != "" {
-					acc.Name = tc.Function.Name
-					emitter.ToolInputStart(acc.ID, acc.Name)
-				}
-				if tc.Function.Arguments != "" {
-					acc.Arguments += tc.Function.Arguments
-					emitter.ToolInputDelta(acc.ID, tc.Function.Arguments)
-				}
-			}
-		}
-
-		if choice.FinishReason != nil {
-			if textStarted {
-				emitter.TextEnd(textID)
-			}
+		result.Choices = append(result.Choices, provider.Choice{
+			Index:        c.Index,
+			Message:      msg,
+			FinishReason: c.FinishReason,
+		})
+	}
 
-			for _, acc := range toolCalls {
-				var inputJSON any
-				if err := json.Unmarshal([]byte(acc.Arguments), &inputJSON); err != nil {
-					slog.Warn("failed to parse tool input as JSON", "error", err, "args", acc.Arguments)
-					inputJSON = acc.Arguments
-				}
-				emitter.ToolInputAvailable(acc.ID, acc.Name, inputJSON)
-			}
+	return result, nil
+}
 
-			emitter.Finish()
-		}
+// contentSegments splits a content field into reasoning and answer text.
+// Reasoning models send a list of chunks with thinking in its own chunk
+// type; other models send a string that may wrap thinking in <think> tags.
+func contentSegments(raw json.RawMessage, parser *provider.ThinkTagParser) []provider.Segment {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil
 	}
 
-	if err := scanner.Err(); err != nil {
-		slog.Error("error scanning stream", "error", err)
-		return nil, fmt.Errorf("scan stream: %w", err)
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return parser.Feed(text)
 	}
 
-	slog.Info("stream completed", "chunks", chunkCount, "content_length", len(fullContent), "tool_calls", len(toolCalls))
-
-	emitter.Done()
-
-	result := &provider.StreamResult{
-		Message: provider.Message{
-			Role:    "assistant",
-			Content: fullContent,
-		},
+	var chunks []struct {
+		Type     string `json:"type"`
+		Text     string `json:"text"`
+		Thinking []struct {
+			Text string `json:"text"`
+		} `json:"thinking"`
+	}
+	if err := json.Unmarshal(raw, &chunks); err != nil {
+		return nil
 	}
 
-	if len(toolCalls) > 0 {
-		result.Message.ToolCalls = make([]provider.ToolCall, 0, len(toolCalls))
-		for _, acc := range toolCalls {
-			result.Message.ToolCalls = append(result.Message.ToolCalls, provider.ToolCall{
-				ID:   acc.ID,
-				Type: acc.Type,
-				Function: provider.FunctionCall{
-					Name:      acc.Name,
-					Arguments: acc.Arguments,
-				},
-			})
+	var segments []provider.Segment
+	for _, chunk := range chunks {
+		switch chunk.Type {
+		case "text":
+			segments = append(segments, parser.Feed(chunk.Text)...)
+		case "thinking":
+			for _, t := range chunk.Thinking {
+				segments = append(segments, provider.Segment{Reasoning: true, Text: t.Text})
+			}
 		}
 	}
+	return segments
+}
 
-	return result, nil
+func (m *mistral) StreamChat(messages []provider.Message, tools []provider.Tool, writer io.Writer) (*provider.StreamResult, error) {
+	return m.StreamChatContext(context.Background(), messages, tools, writer)
 }
 
-type accumulatedToolCall struct {
-	ID        string
-	Type      string
-	Name      string
-	Arguments string
+func (m *mistral) StreamChatContext(ctx context.Context, messages []provider.Message, tools []provider.Tool, writer io.Writer) (*provider.StreamResult, error) {
+	req := m.newChatRequest(ctx, messages, tools)
+	req.Stream = true
+
+	resp, err := m.post(ctx, "/chat/completions", req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return chatcompletions.ReadStream(resp.Body, writer, contentSegments)
 }
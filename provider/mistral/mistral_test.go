@@ -0,0 +1,210 @@
+package mistral
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/alexisbouchez/palm/provider"
+	"github.com/alexisbouchez/palm/provider/providertest"
+)
+
+// captureServer answers every request with response and records the body of
+// the last one.
+func captureServer(t *testing.T, response string) (*httptest.Server, *map[string]any) {
+	t.Helper()
+
+	body := map[string]any{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		body = map[string]any{}
+		if err := json.Unmarshal(data, &body); err != nil {
+			t.Errorf("decode request: %v", err)
+		}
+		io.WriteString(w, response)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &body
+}
+
+const okResponse = `{"choices":[{"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}`
+
+func eventTypes(t *testing.T, out string) []string {
+	t.Helper()
+
+	var types []string
+	for _, frame := range strings.Split(strings.TrimSpace(out), "\n\n") {
+		data := strings.TrimPrefix(frame, "data: ")
+		if data == "[DONE]" {
+			types = append(types, data)
+			continue
+		}
+		var event struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			t.Fatalf("bad event %q: %v", frame, err)
+		}
+		types = append(types, event.Type)
+	}
+	return types
+}
+
+func TestStreamThinkTagsSplitText(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		providertest.OpenAIWire.WriteStream(w, providertest.Script{
+			TextChunks:   []string{"<think>a</think>b", "<think>c</think>d"},
+			FinishReason: provider.FinishReasonStop,
+		})
+	}))
+	defer srv.Close()
+
+	var out bytes.Buffer
+	result, err := New().WithBaseURL(srv.URL).StreamChatContext(context.Background(), []provider.Message{{Role: "user", Content: "hi"}}, nil, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"start",
+		"reasoning-start", "reasoning-delta", "reasoning-end",
+		"text-start", "text-delta", "text-end",
+		"reasoning-start", "reasoning-delta", "reasoning-end",
+		"text-start", "text-delta", "text-end",
+		"finish", "[DONE]",
+	}
+	if got := eventTypes(t, out.String()); !reflect.DeepEqual(got, want) {
+		t.Errorf("events = %v\nwant %v", got, want)
+	}
+	if result.Message.Content != "bd" || result.Message.Reasoning != "ac" {
+		t.Errorf("content = %q, reasoning = %q", result.Message.Content, result.Message.Reasoning)
+	}
+}
+
+func TestGenerationOptions(t *testing.T) {
+	srv, body := captureServer(t, okResponse)
+
+	p := New().WithBaseURL(srv.URL).WithGenerationOptions(provider.GenerationOptions{
+		Temperature:      provider.Ptr(0.2),
+		TopP:             provider.Ptr(0.9),
+		MaxTokens:        provider.Ptr(256),
+		Stop:             []string{"END"},
+		RandomSeed:       provider.Ptr(42),
+		PresencePenalty:  provider.Ptr(0.5),
+		FrequencyPenalty: provider.Ptr(0.25),
+		SafePrompt:       provider.Ptr(true),
+	})
+	ctx := provider.ContextWithGenerationOptions(context.Background(), provider.GenerationOptions{
+		Temperature: provider.Ptr(0.0),
+	})
+	if _, err := p.ChatContext(ctx, []provider.Message{{Role: "user", Content: "hi"}}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]any{
+		"temperature":       0.0,
+		"top_p":             0.9,
+		"max_tokens":        256.0,
+		"stop":              []any{"END"},
+		"random_seed":       42.0,
+		"presence_penalty":  0.5,
+		"frequency_penalty": 0.25,
+		"safe_prompt":       true,
+	}
+	for key, value := range want {
+		if got := (*body)[key]; !reflect.DeepEqual(got, value) {
+			t.Errorf("%s = %v, want %v", key, got, value)
+		}
+	}
+
+	// Unset options are left to the API's defaults.
+	if _, err := New().WithBaseURL(srv.URL).ChatContext(context.Background(), []provider.Message{{Role: "user", Content: "hi"}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	for key := range want {
+		if _, ok := (*body)[key]; ok {
+			t.Errorf("%s sent without being set", key)
+		}
+	}
+}
+
+func TestStreamFinishReason(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		providertest.OpenAIWire.WriteStream(w, providertest.Script{
+			TextChunks:   []string{"cut"},
+			FinishReason: provider.FinishReasonModelLength,
+		})
+	}))
+	defer srv.Close()
+
+	var out bytes.Buffer
+	result, err := New().WithBaseURL(srv.URL).StreamChatContext(context.Background(), []provider.Message{{Role: "user", Content: "hi"}}, nil, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.FinishReason != provider.FinishReasonModelLength {
+		t.Errorf("finish reason = %q", result.FinishReason)
+	}
+	if !strings.Contains(out.String(), `"finishReason":"length"`) {
+		t.Errorf("finish event does not say length:\n%s", out.String())
+	}
+}
+
+func TestContentChunks(t *testing.T) {
+	got := toContentChunks([]provider.ContentPart{
+		provider.TextPart("what is this?"),
+		provider.ImageURLPart("https://example.com/cat.png"),
+		provider.DocumentURLPart("https://example.com/report.pdf", "report.pdf"),
+		provider.DataPart("image/png", []byte{1}),
+		provider.DataPart("text/plain", []byte("notes")),
+		{Type: provider.ContentTypeData, MediaType: "application/pdf", Name: "scan.pdf", Data: []byte("%PDF")},
+	})
+
+	want := []contentChunk{
+		{Type: "text", Text: "what is this?"},
+		{Type: "image_url", ImageURL: "https://example.com/cat.png"},
+		{Type: "document_url", DocumentURL: "https://example.com/report.pdf", DocumentName: "report.pdf"},
+		{Type: "image_url", ImageURL: "data:image/png;base64,AQ=="},
+		{Type: "text", Text: "notes"},
+		{Type: "document_url", DocumentURL: "data:application/pdf;base64,JVBERg==", DocumentName: "scan.pdf"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("chunks = %+v\nwant %+v", got, want)
+	}
+}
+
+func TestToolChoice(t *testing.T) {
+	srv, body := captureServer(t, okResponse)
+	tools := []provider.Tool{{Type: "function", Function: provider.ToolFunction{Name: "extract_fields", Parameters: json.RawMessage(`{"type":"object"}`)}}}
+
+	tests := []struct {
+		choice *provider.ToolChoice
+		want   any
+	}{
+		{provider.ToolChoiceOf(provider.ToolChoiceAuto), "auto"},
+		{provider.ToolChoiceOf(provider.ToolChoiceNone), "none"},
+		{provider.ToolChoiceOf(provider.ToolChoiceRequired), "any"},
+		{provider.ForceTool("extract_fields"), map[string]any{"type": "function", "function": map[string]any{"name": "extract_fields"}}},
+	}
+	for _, tt := range tests {
+		ctx := provider.ContextWithGenerationOptions(context.Background(), provider.GenerationOptions{
+			ToolChoice:        tt.choice,
+			ParallelToolCalls: provider.Ptr(false),
+		})
+		if _, err := New().WithBaseURL(srv.URL).ChatContext(ctx, []provider.Message{{Role: "user", Content: "hi"}}, tools); err != nil {
+			t.Fatal(err)
+		}
+		if got := (*body)["tool_choice"]; !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%+v: tool_choice = %v, want %v", *tt.choice, got, tt.want)
+		}
+		if got := (*body)["parallel_tool_calls"]; got != false {
+			t.Errorf("parallel_tool_calls = %v, want false", got)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package mistral
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/alexisbouchez/palm/provider"
+)
+
+// knownModels is used when the models endpoint cannot be reached. It only
+// covers the -latest aliases of the main model families.
+var knownModels = []provider.ModelInfo{
+	{
+		ID:            "mistral-large-latest",
+		Description:   "Top-tier model for complex tasks",
+		ContextLength: 131072,
+		Capabilities:  provider.ModelCapabilities{Chat: true, FunctionCalling: true},
+	},
+	{
+		ID:            "mistral-medium-latest",
+		Description:   "Frontier-class multimodal model",
+		ContextLength: 131072,
+		Capabilities:  provider.ModelCapabilities{Chat: true, FunctionCalling: true, Vision: true},
+	},
+	{
+		ID:            "mistral-small-latest",
+		Description:   "Efficient multimodal model",
+		ContextLength: 131072,
+		Capabilities:  provider.ModelCapabilities{Chat: true, FunctionCalling: true, Vision: true},
+	},
+	{
+		ID:            "magistral-medium-latest",
+		Description:   "Reasoning model",
+		ContextLength: 131072,
+		Capabilities:  provider.ModelCapabilities{Chat: true, FunctionCalling: true},
+	},
+	{
+		ID:            "magistral-small-latest",
+		Description:   "Small reasoning model",
+		ContextLength: 131072,
+		Capabilities:  provider.ModelCapabilities{Chat: true, FunctionCalling: true},
+	},
+	{
+		ID:            "pixtral-large-latest",
+		Description:   "Large vision model",
+		ContextLength: 131072,
+		Capabilities:  provider.ModelCapabilities{Chat: true, FunctionCalling: true, Vision: true},
+	},
+	{
+		ID:            "codestral-latest",
+		Description:   "Code generation and fill-in-the-middle",
+		ContextLength: 262144,
+		Capabilities:  provider.ModelCapabilities{Chat: true, FunctionCalling: true, FIM: true},
+	},
+	{
+		ID:            "ministral-8b-latest",
+		Description:   "Edge model",
+		ContextLength: 131072,
+		Capabilities:  provider.ModelCapabilities{Chat: true, FunctionCalling: true},
+	},
+	{
+		ID:            "open-mistral-nemo",
+		Description:   "Open 12B model",
+		ContextLength: 131072,
+		Capabilities:  provider.ModelCapabilities{Chat: true, FunctionCalling: true},
+	},
+	{
+		ID:            "mistral-embed",
+		Description:   "Text embeddings",
+		ContextLength: 8192,
+		Capabilities:  provider.ModelCapabilities{Embedding: true},
+	},
+	{
+		ID:            "codestral-embed",
+		Description:   "Code embeddings",
+		ContextLength: 8192,
+		Capabilities:  provider.ModelCapabilities{Embedding: true},
+	},
+}
+
+type modelCard struct {
+	ID               string   `json:"id"`
+	Description      string   `json:"description"`
+	MaxContextLength int      `json:"max_context_length"`
+	Aliases          []string `json:"aliases"`
+	Capabilities     struct {
+		CompletionChat  bool `json:"completion_chat"`
+		CompletionFIM   bool `json:"completion_fim"`
+		FunctionCalling bool `json:"function_calling"`
+		Vision          bool `json:"vision"`
+	} `json:"capabilities"`
+}
+
+func (c modelCard) toModelInfo() provider.ModelInfo {
+	return provider.ModelInfo{
+		ID:            c.ID,
+		Description:   c.Description,
+		ContextLength: c.MaxContextLength,
+		Aliases:       c.Aliases,
+		Capabilities: provider.ModelCapabilities{
+			Chat:            c.Capabilities.CompletionChat,
+			FunctionCalling: c.Capabilities.FunctionCalling,
+			Vision:          c.Capabilities.Vision,
+			FIM:             c.Capabilities.CompletionFIM,
+			Embedding:       strings.Contains(c.ID, "embed"),
+		},
+	}
+}
+
+// fallbackTTL is how long the built-in list stands in for a failed listing
+// before the API is asked again.
+const fallbackTTL = 5 * time.Minute
+
+// ListModels returns the models available to the API key, falling back to
+// a built-in list when the API cannot be reached or fails with a 5xx. Other
+// API errors, such as an invalid key, are returned as an *APIError. A
+// successful listing is cached for the lifetime of the provider, the
+// fallback for fallbackTTL.
+func (m *mistral) ListModels(ctx context.Context) ([]provider.ModelInfo, error) {
+	m.modelsMu.Lock()
+	defer m.modelsMu.Unlock()
+
+	if m.models != nil && (m.modelsExpires.IsZero() || time.Now().Before(m.modelsExpires)) {
+		return m.models, nil
+	}
+
+	models, err := m.fetchModels(ctx)
+	if err != nil {
+		if ctx.Err() != nil || !unreachable(err) {
+			return nil, err
+		}
+		slog.Warn("listing models failed, using built-in list", "error", err)
+		m.models = knownModels
+		m.modelsExpires = time.Now().Add(fallbackTTL)
+		return knownModels, nil
+	}
+	m.models = models
+	m.modelsExpires = time.Time{}
+	return models, nil
+}
+
+// unreachable reports whether err means the API could not serve the
+// listing at all, as opposed to rejecting the request.
+func unreachable(err error) bool {
+	var apiErr *provider.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode >= http.StatusInternalServerError
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+func (m *mistral) DescribeModel(ctx context.Context, id string) (*provider.ModelInfo, error) {
+	if id == "" {
+		id = m.model
+	}
+
+	models, err := m.ListModels(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := provider.FindModel(models, id); err == nil {
+		return info, nil
+	}
+	// Fine-tuned and dated models may be missing from the built-in list.
+	if info, err := provider.FindModel(knownModels, id); err == nil {
+		return info, nil
+	}
+	return nil, fmt.Errorf("%w: %s", provider.ErrModelNotFound, id)
+}
+
+func (m *mistral) fetchModels(ctx context.Context) ([]provider.ModelInfo, error) {
+	resp, err := m.retry.Do(ctx, m.httpClient, func() (*http.Request, error) {
+		httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, m.baseURL+"/models", nil)
+		if err != nil {
+			return nil, fmt.Errorf("create request: %w", err)
+		}
+		httpReq.Header.Set("Authorization", "Bearer "+m.apiKey)
+		return httpReq, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var list struct {
+		Data []modelCard `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+
+	models := make([]provider.ModelInfo, 0, len(list.Data))
+	for _, card := range list.Data {
+		models = append(models, card.toModelInfo())
+	}
+	return models, nil
+}
@@ -0,0 +1,95 @@
+package mistral
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/alexisbouchez/palm/provider"
+)
+
+func TestListModelsCachesFallback(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		http.Error(w, "bad gateway", http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	p := New().WithBaseURL(srv.URL).WithRetryPolicy(provider.RetryPolicy{MaxAttempts: 1})
+	lister := p.(provider.ModelLister)
+
+	for range 3 {
+		info, err := lister.DescribeModel(context.Background(), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !info.Capabilities.FunctionCalling {
+			t.Errorf("%s: want function calling from the built-in list", info.ID)
+		}
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("requested /models %d times, want 1", n)
+	}
+}
+
+func TestListModelsRetriesAfterFallbackExpires(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"data":[{"id":"custom-model","capabilities":{"completion_chat":true}}]}`))
+	}))
+	defer srv.Close()
+
+	m := New().WithBaseURL(srv.URL).WithRetryPolicy(provider.RetryPolicy{MaxAttempts: 1}).(*mistral)
+	if _, err := m.ListModels(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	m.modelsExpires = m.modelsExpires.Add(-2 * fallbackTTL)
+	models, err := m.ListModels(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(models) != 1 || models[0].ID != "custom-model" {
+		t.Errorf("models = %+v, want the listing from the API", models)
+	}
+}
+
+func TestListModelsReturnsClientErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"message":"Unauthorized"}`, http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	lister := New().WithBaseURL(srv.URL).(provider.ModelLister)
+	for _, call := range []func() error{
+		func() error { _, err := lister.ListModels(context.Background()); return err },
+		func() error { _, err := lister.DescribeModel(context.Background(), ""); return err },
+	} {
+		var apiErr *provider.APIError
+		if err := call(); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+			t.Errorf("err = %v, want a 401 APIError", err)
+		}
+	}
+}
+
+func TestListModelsFallsBackWhenUnreachable(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	url := srv.URL
+	srv.Close()
+
+	models, err := New().WithBaseURL(url).WithRetryPolicy(provider.RetryPolicy{MaxAttempts: 1}).(provider.ModelLister).ListModels(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(models) != len(knownModels) {
+		t.Errorf("got %d models, want the built-in list", len(models))
+	}
+}
@@ -0,0 +1,49 @@
+package provider
+
+import (
+	"context"
+	"errors"
+)
+
+var (
+	ErrModelNotFound = errors.New("model not found")
+	ErrNotSupported  = errors.New("not supported by provider")
+)
+
+type ModelCapabilities struct {
+	Chat            bool `json:"chat"`
+	FunctionCalling bool `json:"function_calling"`
+	Vision          bool `json:"vision"`
+	FIM             bool `json:"fim"`
+	Embedding       bool `json:"embedding"`
+}
+
+type ModelInfo struct {
+	ID            string            `json:"id"`
+	Description   string            `json:"description,omitempty"`
+	ContextLength int               `json:"context_length,omitempty"`
+	Aliases       []string          `json:"aliases,omitempty"`
+	Capabilities  ModelCapabilities `json:"capabilities"`
+}
+
+// ModelLister is implemented by providers that can report which models they
+// serve. DescribeModel with an empty id describes the configured model.
+type ModelLister interface {
+	ListModels(ctx context.Context) ([]ModelInfo, error)
+	DescribeModel(ctx context.Context, id string) (*ModelInfo, error)
+}
+
+// FindModel looks id up among models by ID or alias.
+func FindModel(models []ModelInfo, id string) (*ModelInfo, error) {
+	for i, m := range models {
+		if m.ID == id {
+			return &models[i], nil
+		}
+		for _, alias := range m.Aliases {
+			if alias == id {
+				return &models[i], nil
+			}
+		}
+	}
+	return nil, ErrModelNotFound
+}
@@ -0,0 +1,18 @@
+package ollama_test
+
+import (
+	"testing"
+
+	"github.com/alexisbouchez/palm/provider"
+	"github.com/alexisbouchez/palm/provider/ollama"
+	"github.com/alexisbouchez/palm/provider/providertest"
+)
+
+func TestConformance(t *testing.T) {
+	providertest.RunConformance(t, providertest.Factory{
+		Wire: providertest.OllamaWire,
+		New: func(baseURL string) provider.Provider {
+			return ollama.New().WithAPIKey("test").WithBaseURL(baseURL)
+		},
+	})
+}
@@ -0,0 +1,402 @@
+package ollama
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/alexisbouchez/palm/provider"
+	"github.com/alexisbouchez/palm/stream"
+)
+
+const baseURL = "http://localhost:11434"
+
+type ollama struct {
+	apiKey     string
+	model      string
+	baseURL    string
+	retry      provider.RetryPolicy
+	httpClient *http.Client
+	options    provider.GenerationOptions
+}
+
+func generateID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func New() provider.Provider {
+	return &ollama{
+		model:      "llama3.1",
+		baseURL:    baseURL,
+		retry:      provider.DefaultRetryPolicy(),
+		httpClient: http.DefaultClient,
+	}
+}
+
+func (o *ollama) WithAPIKey(key string) provider.Provider {
+	o.apiKey = key
+	return o
+}
+
+func (o *ollama) WithModel(model string) provider.Provider {
+	o.model = model
+	return o
+}
+
+func (o *ollama) WithBaseURL(url string) provider.Provider {
+	o.baseURL = strings.TrimSuffix(url, "/")
+	return o
+}
+
+func (o *ollama) WithRetryPolicy(policy provider.RetryPolicy) provider.Provider {
+	o.retry = policy
+	return o
+}
+
+func (o *ollama) WithHTTPClient(client *http.Client) provider.Provider {
+	o.httpClient = client
+	return o
+}
+
+func (o *ollama) WithGenerationOptions(opts provider.GenerationOptions) provider.Provider {
+	o.options = opts
+	return o
+}
+
+type chatRequest struct {
+	Model    string          `json:"model"`
+	Messages []message       `json:"messages"`
+	Tools    []provider.Tool `json:"tools,omitempty"`
+	Stream   bool            `json:"stream"`
+	Format   json.RawMessage `json:"format,omitempty"`
+	Options  *modelOptions   `json:"options,omitempty"`
+}
+
+type modelOptions struct {
+	Temperature      *float64 `json:"temperature,omitempty"`
+	TopP             *float64 `json:"top_p,omitempty"`
+	NumPredict       *int     `json:"num_predict,omitempty"`
+	Stop             []string `json:"stop,omitempty"`
+	Seed             *int     `json:"seed,omitempty"`
+	PresencePenalty  *float64 `json:"presence_penalty,omitempty"`
+	FrequencyPenalty *float64 `json:"frequency_penalty,omitempty"`
+}
+
+func (o *ollama) newChatRequest(ctx context.Context, messages []provider.Message, tools []provider.Tool, stream bool) chatRequest {
+	opts := o.options.Merge(provider.GenerationOptionsFromContext(ctx))
+	// Ollama has no tool_choice, so the only setting it can honor is turning
+	// tools off.
+	if opts.ToolChoice != nil && opts.ToolChoice.Mode == provider.ToolChoiceNone {
+		tools = nil
+	}
+	return chatRequest{
+		Model:    o.model,
+		Messages: toMessages(messages),
+		Tools:    tools,
+		Stream:   stream,
+		Format:   toFormat(opts.ResponseFormat),
+		Options: &modelOptions{
+			Temperature:      opts.Temperature,
+			TopP:             opts.TopP,
+			NumPredict:       opts.MaxTokens,
+			Stop:             opts.Stop,
+			Seed:             opts.RandomSeed,
+			PresencePenalty:  opts.PresencePenalty,
+			FrequencyPenalty: opts.FrequencyPenalty,
+		},
+	}
+}
+
+// toFormat maps a response format onto Ollama's format field, which takes
+// either "json" or a JSON schema.
+func toFormat(format *provider.ResponseFormat) json.RawMessage {
+	switch {
+	case format == nil:
+		return nil
+	case format.Type == provider.ResponseFormatJSONSchema && format.Schema != nil:
+		return format.Schema
+	case format.Type == provider.ResponseFormatJSONObject:
+		return json.RawMessage(`"json"`)
+	default:
+		return nil
+	}
+}
+
+type message struct {
+	Role      string     `json:"role"`
+	Content   string     `json:"content"`
+	Images    [][]byte   `json:"images,omitempty"`
+	ToolCalls []toolCall `json:"tool_calls,omitempty"`
+	ToolName  string     `json:"tool_name,omitempty"`
+}
+
+type toolCall struct {
+	Function toolCallFunction `json:"function"`
+}
+
+type toolCallFunction struct {
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+type chatChunk struct {
+	Model           string  `json:"model"`
+	Message         message `json:"message"`
+	Done            bool    `json:"done"`
+	DoneReason      string  `json:"done_reason,omitempty"`
+	PromptEvalCount int     `json:"prompt_eval_count,omitempty"`
+	EvalCount       int     `json:"eval_count,omitempty"`
+	Error           string  `json:"error,omitempty"`
+}
+
+func (c chatChunk) finishReason() provider.FinishReason {
+	// Ollama reports "stop" even when the model asked for tools.
+	if len(c.Message.ToolCalls) > 0 {
+		return provider.FinishReasonToolCalls
+	}
+	return provider.FinishReason(c.DoneReason)
+}
+
+func (c chatChunk) usage() provider.Usage {
+	return provider.Usage{
+		PromptTokens:     c.PromptEvalCount,
+		CompletionTokens: c.EvalCount,
+		TotalTokens:      c.PromptEvalCount + c.EvalCount,
+	}
+}
+
+// Ollama has no tool call IDs, so tool results are matched back to the
+// name of the call they answer.
+func toMessages(messages []provider.Message) []message {
+	names := make(map[string]string)
+	out := make([]message, 0, len(messages))
+
+	for _, msg := range messages {
+		m := message{
+			Role:    msg.Role,
+			Content: msg.Text(),
+		}
+
+		// Ollama only accepts inline base64 images; it cannot fetch URLs
+		// or read documents.
+		for _, p := range msg.Parts {
+			switch {
+			case p.Type == provider.ContentTypeImageURL:
+				if _, data, ok := provider.ParseDataURL(p.URL); ok {
+					m.Images = append(m.Images, data)
+					continue
+				}
+				slog.Warn("ollama does not support image URLs, skipping", "url", p.URL)
+			case p.Type == provider.ContentTypeData && p.IsImage():
+				m.Images = append(m.Images, p.Data)
+			case p.Type == provider.ContentTypeData:
+				slog.Warn("ollama does not support documents, skipping", "media_type", p.MediaType, "name", p.Name)
+			case p.Type == provider.ContentTypeDocumentURL:
+				slog.Warn("ollama does not support documents, skipping", "url", p.URL, "name", p.Name)
+			}
+		}
+
+		for _, tc := range msg.ToolCalls {
+			names[tc.ID] = tc.Function.Name
+			args := json.RawMessage(tc.Function.Arguments)
+			if !json.Valid(args) {
+				args = json.RawMessage("{}")
+			}
+			m.ToolCalls = append(m.ToolCalls, toolCall{
+				Function: toolCallFunction{
+					Name:      tc.Function.Name,
+					Arguments: args,
+				},
+			})
+		}
+
+		if msg.Role == "tool" {
+			m.ToolName = names[msg.ToolCallID]
+		}
+
+		out = append(out, m)
+	}
+
+	return out
+}
+
+func (o *ollama) post(ctx context.Context, req chatRequest) (*http.Response, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request: %w", err)
+	}
+
+	return o.retry.Do(ctx, o.httpClient, func() (*http.Request, error) {
+		httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, o.baseURL+"/api/chat", bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("create request: %w", err)
+		}
+
+		httpReq.Header.Set("Content-Type", "application/json")
+		if o.apiKey != "" {
+			httpReq.Header.Set("Authorization", "Bearer "+o.apiKey)
+		}
+
+		return httpReq, nil
+	})
+}
+
+func toToolCalls(calls []toolCall) []provider.ToolCall {
+	var out []provider.ToolCall
+	for _, tc := range calls {
+		args := string(tc.Function.Arguments)
+		if args == "" || args == "null" {
+			args = "{}"
+		}
+		out = append(out, provider.ToolCall{
+			ID:   "call_" + generateID(),
+			Type: "function",
+			Function: provider.FunctionCall{
+				Name:      tc.Function.Name,
+				Arguments: args,
+			},
+		})
+	}
+	return out
+}
+
+func (o *ollama) Chat(messages []provider.Message, tools []provider.Tool) (*provider.ChatResponse, error) {
+	return o.ChatContext(context.Background(), messages, tools)
+}
+
+func (o *ollama) ChatContext(ctx context.Context, messages []provider.Message, tools []provider.Tool) (*provider.ChatResponse, error) {
+	resp, err := o.post(ctx, o.newChatRequest(ctx, messages, tools, false))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var chunk chatChunk
+	if err := json.NewDecoder(resp.Body).Decode(&chunk); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+
+	return &provider.ChatResponse{
+		ID: generateID(),
+		Choices: []provider.Choice{{
+			Message: provider.Message{
+				Role:      "assistant",
+				Content:   chunk.Message.Content,
+				ToolCalls: toToolCalls(chunk.Message.ToolCalls),
+			},
+			FinishReason: chunk.finishReason(),
+		}},
+		Usage: chunk.usage(),
+	}, nil
+}
+
+func (o *ollama) StreamChat(messages []provider.Message, tools []provider.Tool, writer io.Writer) (*provider.StreamResult, error) {
+	return o.StreamChatContext(context.Background(), messages, tools, writer)
+}
+
+func (o *ollama) StreamChatContext(ctx context.Context, messages []provider.Message, tools []provider.Tool, writer io.Writer) (*provider.StreamResult, error) {
+	resp, err := o.post(ctx, o.newChatRequest(ctx, messages, tools, true))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	emitter := stream.NewEmitter(writer)
+
+	emitter.Start(generateID())
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	var toolCalls []provider.ToolCall
+	textID := ""
+	textEnded := false
+	var fullContent strings.Builder
+	var usage provider.Usage
+	var finishReason provider.FinishReason
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var chunk chatChunk
+		if err := json.Unmarshal([]byte(line), &chunk); err != nil {
+			continue
+		}
+
+		if chunk.Error != "" {
+			emitter.Error(chunk.Error)
+			return nil, fmt.Errorf("stream error: %s", chunk.Error)
+		}
+
+		if chunk.Message.Content != "" {
+			// Text after a tool call goes into a new part.
+			if textID == "" || textEnded {
+				textID = generateID()
+				emitter.TextStart(textID)
+				textEnded = false
+			}
+			emitter.TextDelta(textID, chunk.Message.Content)
+			fullContent.WriteString(chunk.Message.Content)
+		}
+
+		// Tool calls arrive complete in a single chunk rather than as deltas.
+		for _, tc := range toToolCalls(chunk.Message.ToolCalls) {
+			if textID != "" && !textEnded {
+				emitter.TextEnd(textID)
+				textEnded = true
+			}
+
+			var inputJSON any
+			if err := json.Unmarshal([]byte(tc.Function.Arguments), &inputJSON); err != nil {
+				inputJSON = tc.Function.Arguments
+			}
+			emitter.ToolInputStart(tc.ID, tc.Function.Name)
+			emitter.ToolInputDelta(tc.ID, tc.Function.Arguments)
+			emitter.ToolInputAvailable(tc.ID, tc.Function.Name, inputJSON)
+			toolCalls = append(toolCalls, tc)
+		}
+
+		if chunk.Done {
+			if textID != "" && !textEnded {
+				emitter.TextEnd(textID)
+			}
+
+			usage = chunk.usage()
+			finishReason = chunk.finishReason()
+			if len(toolCalls) > 0 {
+				finishReason = provider.FinishReasonToolCalls
+			}
+			break
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("scan stream: %w", err)
+	}
+
+	emitter.Finish(finishReason.StreamReason(), map[string]any{"usage": usage})
+	emitter.Done()
+
+	return &provider.StreamResult{
+		Usage:        usage,
+		FinishReason: finishReason,
+		Message: provider.Message{
+			Role:      "assistant",
+			Content:   fullContent.String(),
+			ToolCalls: toolCalls,
+		},
+	}, nil
+}
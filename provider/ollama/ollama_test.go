@@ -0,0 +1,24 @@
+package ollama
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/alexisbouchez/palm/provider"
+)
+
+func TestToolChoiceNoneDropsTools(t *testing.T) {
+	o := New().(*ollama)
+	messages := []provider.Message{{Role: "user", Content: "hi"}}
+	tools := []provider.Tool{{Type: "function", Function: provider.ToolFunction{Name: "search", Parameters: json.RawMessage(`{}`)}}}
+
+	if req := o.newChatRequest(context.Background(), messages, tools, false); len(req.Tools) != 1 {
+		t.Errorf("tools = %+v, want them sent by default", req.Tools)
+	}
+
+	ctx := provider.ContextWithGenerationOptions(context.Background(), provider.GenerationOptions{ToolChoice: provider.ToolChoiceOf(provider.ToolChoiceNone)})
+	if req := o.newChatRequest(ctx, messages, tools, false); req.Tools != nil {
+		t.Errorf("tools = %+v, want none", req.Tools)
+	}
+}
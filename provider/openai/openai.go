@@ -0,0 +1,194 @@
+package openai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/alexisbouchez/palm/provider"
+	"github.com/alexisbouchez/palm/provider/internal/chatcompletions"
+)
+
+const baseURL = "https://api.openai.com/v1"
+
+type openai struct {
+	apiKey     string
+	model      string
+	baseURL    string
+	retry      provider.RetryPolicy
+	httpClient *http.Client
+	options    provider.GenerationOptions
+}
+
+func New() provider.Provider {
+	return &openai{
+		model:      "gpt-4o-mini",
+		baseURL:    baseURL,
+		retry:      provider.DefaultRetryPolicy(),
+		httpClient: http.DefaultClient,
+	}
+}
+
+func (o *openai) WithAPIKey(key string) provider.Provider {
+	o.apiKey = key
+	return o
+}
+
+func (o *openai) WithModel(model string) provider.Provider {
+	o.model = model
+	return o
+}
+
+func (o *openai) WithBaseURL(url string) provider.Provider {
+	o.baseURL = strings.TrimSuffix(url, "/")
+	return o
+}
+
+func (o *openai) WithRetryPolicy(policy provider.RetryPolicy) provider.Provider {
+	o.retry = policy
+	return o
+}
+
+func (o *openai) WithHTTPClient(client *http.Client) provider.Provider {
+	o.httpClient = client
+	return o
+}
+
+func (o *openai) WithGenerationOptions(opts provider.GenerationOptions) provider.Provider {
+	o.options = opts
+	return o
+}
+
+type chatRequest struct {
+	chatcompletions.Request
+	Seed          *int           `json:"seed,omitempty"`
+	StreamOptions *streamOptions `json:"stream_options,omitempty"`
+}
+
+type streamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+type contentPart struct {
+	Type     string    `json:"type"`
+	Text     string    `json:"text,omitempty"`
+	ImageURL *imageURL `json:"image_url,omitempty"`
+	File     *file     `json:"file,omitempty"`
+}
+
+type imageURL struct {
+	URL string `json:"url"`
+}
+
+type file struct {
+	FileData string `json:"file_data"`
+	Filename string `json:"filename,omitempty"`
+}
+
+func toContentParts(parts []provider.ContentPart) []contentPart {
+	out := make([]contentPart, 0, len(parts))
+	for _, p := range parts {
+		switch {
+		case p.Type == provider.ContentTypeText:
+			out = append(out, contentPart{Type: "text", Text: p.Text})
+		case p.Type == provider.ContentTypeImageURL:
+			out = append(out, contentPart{Type: "image_url", ImageURL: &imageURL{URL: p.URL}})
+		case p.Type == provider.ContentTypeDocumentURL:
+			// Chat completions only accept inline files, so remote documents
+			// are passed by reference.
+			out = append(out, contentPart{Type: "text", Text: "Document: " + p.URL})
+		case p.IsImage():
+			out = append(out, contentPart{Type: "image_url", ImageURL: &imageURL{URL: p.DataURL()}})
+		case strings.HasPrefix(p.MediaType, "text/"):
+			out = append(out, contentPart{Type: "text", Text: string(p.Data)})
+		default:
+			out = append(out, contentPart{Type: "file", File: &file{FileData: p.DataURL(), Filename: p.Name}})
+		}
+	}
+	return out
+}
+
+func (o *openai) newChatRequest(ctx context.Context, messages []provider.Message, tools []provider.Tool) chatRequest {
+	opts := o.options.Merge(provider.GenerationOptionsFromContext(ctx))
+	return chatRequest{
+		Request: chatcompletions.NewRequest(o.model, chatcompletions.ToMessages(messages, toContentParts), tools, opts, "required"),
+		Seed:    opts.RandomSeed,
+	}
+}
+
+func (o *openai) post(ctx context.Context, req chatRequest) (*http.Response, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request: %w", err)
+	}
+
+	return o.retry.Do(ctx, o.httpClient, func() (*http.Request, error) {
+		httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, o.baseURL+"/chat/completions", bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("create request: %w", err)
+		}
+
+		httpReq.Header.Set("Content-Type", "application/json")
+		// Self-hosted servers (vLLM, llama.cpp, LM Studio) usually run without a key.
+		if o.apiKey != "" {
+			httpReq.Header.Set("Authorization", "Bearer "+o.apiKey)
+		}
+
+		return httpReq, nil
+	})
+}
+
+func (o *openai) Chat(messages []provider.Message, tools []provider.Tool) (*provider.ChatResponse, error) {
+	return o.ChatContext(context.Background(), messages, tools)
+}
+
+func (o *openai) ChatContext(ctx context.Context, messages []provider.Message, tools []provider.Tool) (*provider.ChatResponse, error) {
+	resp, err := o.post(ctx, o.newChatRequest(ctx, messages, tools))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var chatResp provider.ChatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&chatResp); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+
+	for i := range chatResp.Choices {
+		msg := &chatResp.Choices[i].Message
+		var parser provider.ThinkTagParser
+		segments := append(parser.Feed(msg.Content), parser.Flush()...)
+		msg.Content = ""
+		for _, seg := range segments {
+			if seg.Reasoning {
+				msg.Reasoning += seg.Text
+			} else {
+				msg.Content += seg.Text
+			}
+		}
+	}
+
+	return &chatResp, nil
+}
+
+func (o *openai) StreamChat(messages []provider.Message, tools []provider.Tool, writer io.Writer) (*provider.StreamResult, error) {
+	return o.StreamChatContext(context.Background(), messages, tools, writer)
+}
+
+func (o *openai) StreamChatContext(ctx context.Context, messages []provider.Message, tools []provider.Tool, writer io.Writer) (*provider.StreamResult, error) {
+	req := o.newChatRequest(ctx, messages, tools)
+	req.Stream = true
+	req.StreamOptions = &streamOptions{IncludeUsage: true}
+
+	resp, err := o.post(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return chatcompletions.ReadStream(resp.Body, writer, chatcompletions.ThinkTagContent)
+}
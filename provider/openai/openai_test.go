@@ -0,0 +1,225 @@
+package openai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alexisbouchez/palm/provider"
+)
+
+var weatherTool = provider.Tool{
+	Type: "function",
+	Function: provider.ToolFunction{
+		Name:       "get_weather",
+		Parameters: json.RawMessage(`{"type":"object","properties":{"location":{"type":"string"}}}`),
+	},
+}
+
+// captureServer answers every request with response and records the last
+// request body and Authorization header.
+func captureServer(t *testing.T, response string) (*httptest.Server, *map[string]any, *string) {
+	t.Helper()
+
+	body := map[string]any{}
+	auth := ""
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/chat/completions" {
+			t.Errorf("path = %q", r.URL.Path)
+		}
+		data, _ := io.ReadAll(r.Body)
+		body = map[string]any{}
+		if err := json.Unmarshal(data, &body); err != nil {
+			t.Errorf("decode request: %v", err)
+		}
+		auth = r.Header.Get("Authorization")
+		io.WriteString(w, response)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &body, &auth
+}
+
+func TestRequestOptions(t *testing.T) {
+	srv, body, auth := captureServer(t, `{"choices":[{"message":{"role":"assistant","content":"ok"}}]}`)
+
+	p := New().WithBaseURL(srv.URL + "/").WithGenerationOptions(provider.GenerationOptions{
+		RandomSeed:        provider.Ptr(7),
+		ToolChoice:        provider.ToolChoiceOf(provider.ToolChoiceRequired),
+		ParallelToolCalls: provider.Ptr(false),
+		ResponseFormat:    &provider.ResponseFormat{Type: provider.ResponseFormatJSONSchema, Name: "answer", Schema: json.RawMessage(`{"type":"object"}`)},
+	})
+
+	if _, err := p.ChatContext(context.Background(), []provider.Message{{Role: "user", Content: "hi"}}, []provider.Tool{weatherTool}); err != nil {
+		t.Fatal(err)
+	}
+
+	got := *body
+	if got["seed"] != 7.0 {
+		t.Errorf("seed = %v, want 7", got["seed"])
+	}
+	if got["tool_choice"] != "required" {
+		t.Errorf("tool_choice = %v, want required", got["tool_choice"])
+	}
+	if got["parallel_tool_calls"] != false {
+		t.Errorf("parallel_tool_calls = %v, want false", got["parallel_tool_calls"])
+	}
+	if rf, _ := got["response_format"].(map[string]any); rf["type"] != "json_schema" || rf["json_schema"] == nil {
+		t.Errorf("response_format = %v", got["response_format"])
+	}
+	if *auth != "" {
+		t.Errorf("Authorization = %q, want none without a key", *auth)
+	}
+
+	// Tool settings must not be sent without tools.
+	p.WithAPIKey("sk-test")
+	if _, err := p.ChatContext(context.Background(), []provider.Message{{Role: "user", Content: "hi"}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"tools", "tool_choice", "parallel_tool_calls"} {
+		if _, ok := (*body)[key]; ok {
+			t.Errorf("%s sent without tools", key)
+		}
+	}
+	if *auth != "Bearer sk-test" {
+		t.Errorf("Authorization = %q", *auth)
+	}
+}
+
+func TestMultimodalContent(t *testing.T) {
+	srv, body, _ := captureServer(t, `{"choices":[{"message":{"role":"assistant","content":"ok"}}]}`)
+
+	msg := provider.Message{Role: "user", Parts: []provider.ContentPart{
+		provider.TextPart("what is this?"),
+		provider.DataPart("image/png", []byte{1, 2, 3}),
+		provider.DataPart("application/pdf", []byte("%PDF")),
+	}}
+	if _, err := New().WithBaseURL(srv.URL).ChatContext(context.Background(), []provider.Message{msg}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	messages := (*body)["messages"].([]any)
+	content := messages[0].(map[string]any)["content"].([]any)
+	var types []string
+	for _, part := range content {
+		types = append(types, part.(map[string]any)["type"].(string))
+	}
+	if strings.Join(types, ",") != "text,image_url,file" {
+		t.Errorf("content part types = %v", types)
+	}
+}
+
+func TestChatToolCalls(t *testing.T) {
+	srv, _, _ := captureServer(t, `{
+		"id": "chatcmpl-1",
+		"choices": [{
+			"index": 0,
+			"message": {"role": "assistant", "tool_calls": [{"id": "call_1", "type": "function", "function": {"name": "get_weather", "arguments": "{\"location\":\"Paris\"}"}}]},
+			"finish_reason": "tool_calls"
+		}],
+		"usage": {"prompt_tokens": 10, "completion_tokens": 5, "total_tokens": 15}
+	}`)
+
+	resp, err := New().WithBaseURL(srv.URL).ChatContext(context.Background(), []provider.Message{{Role: "user", Content: "weather?"}}, []provider.Tool{weatherTool})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	choice := resp.Choices[0]
+	if choice.FinishReason != provider.FinishReasonToolCalls {
+		t.Errorf("finish reason = %q", choice.FinishReason)
+	}
+	if len(choice.Message.ToolCalls) != 1 || choice.Message.ToolCalls[0].Function.Arguments != `{"location":"Paris"}` {
+		t.Errorf("tool calls = %+v", choice.Message.ToolCalls)
+	}
+	if resp.Usage.TotalTokens != 15 {
+		t.Errorf("usage = %+v", resp.Usage)
+	}
+}
+
+// Self-hosted servers often omit call IDs and send the legacy function_call
+// finish reason.
+func TestStreamToolCallsWithoutIDs(t *testing.T) {
+	chunks := []string{
+		`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"name":"get_weather","arguments":"{\"location\":"}}]}}]}`,
+		`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"Oslo\"}"}}]}}]}`,
+		`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":1,"function":{"name":"get_weather","arguments":"{\"location\":\"Rome\"}"}}]}}]}`,
+		`{"choices":[{"index":0,"delta":{},"finish_reason":"function_call"}]}`,
+	}
+	var sse strings.Builder
+	for _, c := range chunks {
+		fmt.Fprintf(&sse, "data:%s\n\n", c)
+	}
+	sse.WriteString("data: [DONE]\n\n")
+	srv, _, _ := captureServer(t, sse.String())
+
+	var out bytes.Buffer
+	result, err := New().WithBaseURL(srv.URL).StreamChatContext(context.Background(), []provider.Message{{Role: "user", Content: "weather?"}}, []provider.Tool{weatherTool}, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.FinishReason != provider.FinishReasonToolCalls {
+		t.Errorf("finish reason = %q", result.FinishReason)
+	}
+	calls := result.Message.ToolCalls
+	if len(calls) != 2 {
+		t.Fatalf("tool calls = %+v", calls)
+	}
+	if calls[0].ID == "" || calls[0].ID == calls[1].ID {
+		t.Errorf("call IDs = %q, %q, want distinct generated IDs", calls[0].ID, calls[1].ID)
+	}
+	if calls[0].Function.Arguments != `{"location":"Oslo"}` || calls[1].Function.Arguments != `{"location":"Rome"}` {
+		t.Errorf("arguments = %q, %q", calls[0].Function.Arguments, calls[1].Function.Arguments)
+	}
+	for _, c := range calls {
+		if !strings.Contains(out.String(), `"toolCallId":"`+c.ID+`","toolName":"get_weather","type":"tool-input-available"`) {
+			t.Errorf("no tool-input-available for %s in:\n%s", c.ID, out.String())
+		}
+	}
+}
+
+func TestStreamThinkTags(t *testing.T) {
+	var sse strings.Builder
+	for _, delta := range []string{"<thi", "nk>The user is ", "greeting.</think>", "Hello", "!"} {
+		content, _ := json.Marshal(delta)
+		fmt.Fprintf(&sse, "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":%s}}]}\n\n", content)
+	}
+	sse.WriteString("data: {\"choices\":[{\"index\":0,\"delta\":{},\"finish_reason\":\"stop\"}]}\n\ndata: [DONE]\n\n")
+	srv, _, _ := captureServer(t, sse.String())
+
+	var out bytes.Buffer
+	result, err := New().WithBaseURL(srv.URL).StreamChatContext(context.Background(), []provider.Message{{Role: "user", Content: "hi"}}, nil, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Message.Reasoning != "The user is greeting." || result.Message.Content != "Hello!" {
+		t.Errorf("reasoning = %q, content = %q", result.Message.Reasoning, result.Message.Content)
+	}
+	for _, typ := range []string{"reasoning-start", "reasoning-delta", "reasoning-end", "text-start"} {
+		if !strings.Contains(out.String(), `"type":"`+typ+`"`) {
+			t.Errorf("no %s in:\n%s", typ, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "think>") {
+		t.Errorf("think tags leaked into the stream:\n%s", out.String())
+	}
+}
+
+func TestChatThinkTags(t *testing.T) {
+	srv, _, _ := captureServer(t, `{"choices":[{"message":{"role":"assistant","content":"<think>Easy sum.</think>4"},"finish_reason":"stop"}]}`)
+
+	resp, err := New().WithBaseURL(srv.URL).ChatContext(context.Background(), []provider.Message{{Role: "user", Content: "2+2?"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg := resp.Choices[0].Message; msg.Reasoning != "Easy sum." || msg.Content != "4" {
+		t.Errorf("reasoning = %q, content = %q", msg.Reasoning, msg.Content)
+	}
+}
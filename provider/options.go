@@ -0,0 +1,123 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// GenerationOptions holds sampling parameters. Nil fields are left to the
+// provider's defaults; providers ignore options their API does not support.
+type GenerationOptions struct {
+	Temperature       *float64        `json:"temperature,omitempty"`
+	TopP              *float64        `json:"top_p,omitempty"`
+	MaxTokens         *int            `json:"max_tokens,omitempty"`
+	Stop              []string        `json:"stop,omitempty"`
+	RandomSeed        *int            `json:"random_seed,omitempty"`
+	PresencePenalty   *float64        `json:"presence_penalty,omitempty"`
+	FrequencyPenalty  *float64        `json:"frequency_penalty,omitempty"`
+	SafePrompt        *bool           `json:"safe_prompt,omitempty"`
+	ResponseFormat    *ResponseFormat `json:"response_format,omitempty"`
+	ToolChoice        *ToolChoice     `json:"tool_choice,omitempty"`
+	ParallelToolCalls *bool           `json:"parallel_tool_calls,omitempty"`
+}
+
+type ToolChoiceMode string
+
+const (
+	ToolChoiceAuto     ToolChoiceMode = "auto"
+	ToolChoiceNone     ToolChoiceMode = "none"
+	ToolChoiceRequired ToolChoiceMode = "required"
+	ToolChoiceFunction ToolChoiceMode = "function"
+)
+
+// ToolChoice controls whether the model may, must or must not call tools.
+// With ToolChoiceFunction the model must call the function named Function.
+type ToolChoice struct {
+	Mode     ToolChoiceMode `json:"mode,omitempty"`
+	Function string         `json:"function,omitempty"`
+}
+
+func ToolChoiceOf(mode ToolChoiceMode) *ToolChoice {
+	return &ToolChoice{Mode: mode}
+}
+
+func ForceTool(name string) *ToolChoice {
+	return &ToolChoice{Mode: ToolChoiceFunction, Function: name}
+}
+
+// Forced reports whether c makes the model call a tool.
+func (c *ToolChoice) Forced() bool {
+	return c != nil && (c.Mode == ToolChoiceRequired || c.Mode == ToolChoiceFunction)
+}
+
+type ResponseFormatType string
+
+const (
+	ResponseFormatText       ResponseFormatType = "text"
+	ResponseFormatJSONObject ResponseFormatType = "json_object"
+	ResponseFormatJSONSchema ResponseFormatType = "json_schema"
+)
+
+// ResponseFormat constrains the shape of the model's reply. Name and Schema
+// are only used with ResponseFormatJSONSchema.
+type ResponseFormat struct {
+	Type   ResponseFormatType `json:"type,omitempty"`
+	Name   string             `json:"name,omitempty"`
+	Schema json.RawMessage    `json:"schema,omitempty"`
+	Strict bool               `json:"strict,omitempty"`
+}
+
+func Ptr[T any](v T) *T {
+	return &v
+}
+
+// Merge returns o with every field that is set in override replaced.
+func (o GenerationOptions) Merge(override GenerationOptions) GenerationOptions {
+	if override.Temperature != nil {
+		o.Temperature = override.Temperature
+	}
+	if override.TopP != nil {
+		o.TopP = override.TopP
+	}
+	if override.MaxTokens != nil {
+		o.MaxTokens = override.MaxTokens
+	}
+	if override.Stop != nil {
+		o.Stop = override.Stop
+	}
+	if override.RandomSeed != nil {
+		o.RandomSeed = override.RandomSeed
+	}
+	if override.PresencePenalty != nil {
+		o.PresencePenalty = override.PresencePenalty
+	}
+	if override.FrequencyPenalty != nil {
+		o.FrequencyPenalty = override.FrequencyPenalty
+	}
+	if override.SafePrompt != nil {
+		o.SafePrompt = override.SafePrompt
+	}
+	if override.ResponseFormat != nil {
+		o.ResponseFormat = override.ResponseFormat
+	}
+	if override.ToolChoice != nil {
+		o.ToolChoice = override.ToolChoice
+	}
+	if override.ParallelToolCalls != nil {
+		o.ParallelToolCalls = override.ParallelToolCalls
+	}
+	return o
+}
+
+type generationOptionsKey struct{}
+
+// ContextWithGenerationOptions overrides generation options for calls made
+// with the returned context, on top of any already present in ctx.
+func ContextWithGenerationOptions(ctx context.Context, opts GenerationOptions) context.Context {
+	return context.WithValue(ctx, generationOptionsKey{}, GenerationOptionsFromContext(ctx).Merge(opts))
+}
+
+func GenerationOptionsFromContext(ctx context.Context) GenerationOptions {
+	opts, _ := ctx.Value(generationOptionsKey{}).(GenerationOptions)
+	return opts
+}
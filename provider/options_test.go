@@ -0,0 +1,49 @@
+package provider
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestMergeOverridesSetFields(t *testing.T) {
+	base := GenerationOptions{
+		Temperature: Ptr(0.2),
+		MaxTokens:   Ptr(100),
+		Stop:        []string{"END"},
+		SafePrompt:  Ptr(true),
+	}
+	got := base.Merge(GenerationOptions{
+		Temperature: Ptr(0.9),
+		RandomSeed:  Ptr(42),
+		SafePrompt:  Ptr(false),
+	})
+
+	want := GenerationOptions{
+		Temperature: Ptr(0.9),
+		MaxTokens:   Ptr(100),
+		Stop:        []string{"END"},
+		RandomSeed:  Ptr(42),
+		SafePrompt:  Ptr(false),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Merge = %+v, want %+v", got, want)
+	}
+	if *base.Temperature != 0.2 {
+		t.Error("Merge changed the receiver")
+	}
+}
+
+func TestContextGenerationOptionsLayer(t *testing.T) {
+	if got := GenerationOptionsFromContext(context.Background()); !reflect.DeepEqual(got, GenerationOptions{}) {
+		t.Errorf("empty context has options %+v", got)
+	}
+
+	ctx := ContextWithGenerationOptions(context.Background(), GenerationOptions{Temperature: Ptr(0.1), TopP: Ptr(0.5)})
+	ctx = ContextWithGenerationOptions(ctx, GenerationOptions{Temperature: Ptr(0.7)})
+
+	got := GenerationOptionsFromContext(ctx)
+	if *got.Temperature != 0.7 || got.TopP == nil || *got.TopP != 0.5 {
+		t.Errorf("options = temperature %v, top_p %v; want 0.7 and 0.5", got.Temperature, got.TopP)
+	}
+}
@@ -1,28 +1,91 @@
 package provider
 
 import (
+	"context"
 	"encoding/json"
 	"io"
+	"net/http"
 )
 
 type Provider interface {
 	WithAPIKey(key string) Provider
 	WithModel(model string) Provider
 	WithBaseURL(url string) Provider
+	WithRetryPolicy(policy RetryPolicy) Provider
+	// WithHTTPClient sets the client used for API requests, for example
+	// one whose transport records them to a cassette.
+	WithHTTPClient(client *http.Client) Provider
+	WithGenerationOptions(opts GenerationOptions) Provider
 
 	Chat(messages []Message, tools []Tool) (*ChatResponse, error)
 	StreamChat(messages []Message, tools []Tool, writer io.Writer) (*StreamResult, error)
+	ChatContext(ctx context.Context, messages []Message, tools []Tool) (*ChatResponse, error)
+	StreamChatContext(ctx context.Context, messages []Message, tools []Tool, writer io.Writer) (*StreamResult, error)
 }
 
 type StreamResult struct {
-	Message Message
+	Message      Message
+	Usage        Usage
+	FinishReason FinishReason
 }
 
+type FinishReason string
+
+const (
+	FinishReasonStop          FinishReason = "stop"
+	FinishReasonLength        FinishReason = "length"
+	FinishReasonModelLength   FinishReason = "model_length"
+	FinishReasonToolCalls     FinishReason = "tool_calls"
+	FinishReasonContentFilter FinishReason = "content_filter"
+	FinishReasonError         FinishReason = "error"
+)
+
+// Truncated reports whether generation stopped because a token limit was
+// reached rather than because the model was done.
+func (r FinishReason) Truncated() bool {
+	return r == FinishReasonLength || r == FinishReasonModelLength
+}
+
+// StreamReason maps r onto the finish reasons of the AI SDK UI stream.
+func (r FinishReason) StreamReason() string {
+	switch r {
+	case FinishReasonStop, FinishReasonError:
+		return string(r)
+	case FinishReasonLength, FinishReasonModelLength:
+		return "length"
+	case FinishReasonToolCalls:
+		return "tool-calls"
+	case FinishReasonContentFilter:
+		return "content-filter"
+	default:
+		return "other"
+	}
+}
+
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+func (u Usage) Add(other Usage) Usage {
+	return Usage{
+		PromptTokens:     u.PromptTokens + other.PromptTokens,
+		CompletionTokens: u.CompletionTokens + other.CompletionTokens,
+		TotalTokens:      u.TotalTokens + other.TotalTokens,
+	}
+}
+
+// Message content is either the plain Content string or, for multimodal
+// messages, Parts; when Parts is set Content is ignored. Reasoning holds the
+// model's thinking, which is kept for display and not sent back.
 type Message struct {
-	Role       string     `json:"role"`
-	Content    string     `json:"content,omitempty"`
-	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`
-	ToolCallID string     `json:"tool_call_id,omitempty"`
+	Role       string        `json:"role"`
+	Content    string        `json:"content,omitempty"`
+	Reasoning  string        `json:"reasoning,omitempty"`
+	Parts      []ContentPart `json:"parts,omitempty"`
+	ToolCalls  []ToolCall    `json:"tool_calls,omitempty"`
+	ToolCallID string        `json:"tool_call_id,omitempty"`
 }
 
 type ToolCall struct {
@@ -50,10 +113,11 @@ type ToolFunction struct {
 type ChatResponse struct {
 	ID      string   `json:"id"`
 	Choices []Choice `json:"choices"`
+	Usage   Usage    `json:"usage"`
 }
 
 type Choice struct {
-	Index        int     `json:"index"`
-	Message      Message `json:"message"`
-	FinishReason string  `json:"finish_reason"`
+	Index        int          `json:"index"`
+	Message      Message      `json:"message"`
+	FinishReason FinishReason `json:"finish_reason"`
 }
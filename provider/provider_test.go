@@ -0,0 +1,36 @@
+package provider
+
+import "testing"
+
+func TestUsageAdd(t *testing.T) {
+	got := Usage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15}.Add(Usage{PromptTokens: 3, CompletionTokens: 2, TotalTokens: 5})
+	if want := (Usage{PromptTokens: 13, CompletionTokens: 7, TotalTokens: 20}); got != want {
+		t.Errorf("Add = %+v, want %+v", got, want)
+	}
+}
+
+func TestFinishReasonStreamReason(t *testing.T) {
+	for reason, want := range map[FinishReason]string{
+		FinishReasonStop:          "stop",
+		FinishReasonLength:        "length",
+		FinishReasonModelLength:   "length",
+		FinishReasonToolCalls:     "tool-calls",
+		FinishReasonContentFilter: "content-filter",
+		FinishReasonError:         "error",
+		"":                        "other",
+		"something_new":           "other",
+	} {
+		if got := reason.StreamReason(); got != want {
+			t.Errorf("%q.StreamReason() = %q, want %q", reason, got, want)
+		}
+	}
+
+	for _, reason := range []FinishReason{FinishReasonLength, FinishReasonModelLength} {
+		if !reason.Truncated() {
+			t.Errorf("%q is not truncated", reason)
+		}
+	}
+	if FinishReasonStop.Truncated() || FinishReasonToolCalls.Truncated() {
+		t.Error("stop and tool_calls count as truncated")
+	}
+}
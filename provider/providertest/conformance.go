@@ -0,0 +1,342 @@
+package providertest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/alexisbouchez/palm/provider"
+	"github.com/alexisbouchez/palm/stream"
+)
+
+// Factory builds the provider under test, pointed at the test server, and
+// names the wire format that server must speak.
+type Factory struct {
+	Wire Wire
+	New  func(baseURL string) provider.Provider
+}
+
+// RunConformance checks that a provider turns its vendor's streaming
+// responses into the same UI message stream and results as every other
+// provider.
+func RunConformance(t *testing.T, factory Factory) {
+	t.Run("TextStream", func(t *testing.T) {
+		runScript(t, factory, Script{
+			TextChunks:   []string{"Hel", "lo, ", "world", "!"},
+			FinishReason: provider.FinishReasonStop,
+			Usage:        provider.Usage{PromptTokens: 12, CompletionTokens: 4, TotalTokens: 16},
+		})
+	})
+
+	t.Run("ToolCalls", func(t *testing.T) {
+		runScript(t, factory, Script{
+			ToolCalls: []ScriptedToolCall{
+				{ID: "call_weather", Name: "get_weather", ArgumentChunks: []string{`{"loca`, `tion":"Pa`, `ris"}`}},
+				{ID: "call_time", Name: "get_time", ArgumentChunks: []string{`{"zone":`, `"CET"}`}},
+			},
+			FinishReason: provider.FinishReasonToolCalls,
+			Usage:        provider.Usage{PromptTokens: 20, CompletionTokens: 9, TotalTokens: 29},
+		})
+	})
+
+	t.Run("TextThenToolCall", func(t *testing.T) {
+		runScript(t, factory, Script{
+			TextChunks: []string{"Let me ", "check."},
+			ToolCalls: []ScriptedToolCall{
+				{ID: "call_weather", Name: "get_weather", ArgumentChunks: []string{`{"location":`, `"Rome"}`}},
+			},
+			FinishReason: provider.FinishReasonToolCalls,
+			Usage:        provider.Usage{PromptTokens: 20, CompletionTokens: 12, TotalTokens: 32},
+		})
+	})
+
+	t.Run("TextAfterToolCall", func(t *testing.T) {
+		runScript(t, factory, Script{
+			TextChunks: []string{"Checking."},
+			ToolCalls: []ScriptedToolCall{
+				{ID: "call_weather", Name: "get_weather", ArgumentChunks: []string{`{"location":"Oslo"}`}},
+			},
+			TrailingTextChunks: []string{"One ", "moment."},
+			FinishReason:       provider.FinishReasonToolCalls,
+			Usage:              provider.Usage{PromptTokens: 20, CompletionTokens: 14, TotalTokens: 34},
+		})
+	})
+
+	t.Run("ErrorStatus", func(t *testing.T) {
+		for _, status := range []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusTooManyRequests, http.StatusInternalServerError} {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, `{"error":{"message":"conformance failure"}}`, status)
+			}))
+
+			p := factory.New(srv.URL).WithRetryPolicy(provider.RetryPolicy{MaxAttempts: 1})
+			var out bytes.Buffer
+			_, err := p.StreamChatContext(context.Background(), testMessages(), nil, &out)
+			srv.Close()
+
+			var apiErr *provider.APIError
+			if !errors.As(err, &apiErr) {
+				t.Errorf("status %d: got error %v, want *provider.APIError", status, err)
+				continue
+			}
+			if apiErr.StatusCode != status {
+				t.Errorf("status %d: APIError.StatusCode = %d", status, apiErr.StatusCode)
+			}
+			if !strings.Contains(apiErr.Body, "conformance failure") {
+				t.Errorf("status %d: APIError.Body = %q, want the response body", status, apiErr.Body)
+			}
+			if out.Len() > 0 {
+				t.Errorf("status %d: wrote %q before failing, want nothing", status, out.String())
+			}
+		}
+	})
+
+	t.Run("HTTPClient", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			factory.Wire.WriteStream(w, Script{TextChunks: []string{"ok"}, FinishReason: provider.FinishReasonStop})
+		}))
+		defer srv.Close()
+
+		var requests int
+		client := &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			requests++
+			return http.DefaultTransport.RoundTrip(r)
+		})}
+		p := factory.New(srv.URL).WithHTTPClient(client)
+		if _, err := p.StreamChatContext(context.Background(), testMessages(), nil, io.Discard); err != nil {
+			t.Fatalf("StreamChatContext: %v", err)
+		}
+		if requests != 1 {
+			t.Errorf("client sent %d requests, want 1", requests)
+		}
+	})
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func testMessages() []provider.Message {
+	return []provider.Message{{Role: "user", Content: "conformance check"}}
+}
+
+func testTools() []provider.Tool {
+	return []provider.Tool{{
+		Type: "function",
+		Function: provider.ToolFunction{
+			Name:        "get_weather",
+			Description: "Get the weather",
+			Parameters:  json.RawMessage(`{"type":"object","properties":{"location":{"type":"string"}}}`),
+		},
+	}}
+}
+
+func runScript(t *testing.T, factory Factory, script Script) {
+	t.Helper()
+
+	var mu sync.Mutex
+	var gotPath, gotBody string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		gotPath, gotBody = r.URL.Path, string(body)
+		mu.Unlock()
+		factory.Wire.WriteStream(w, script)
+	}))
+	defer srv.Close()
+
+	p := factory.New(srv.URL).WithRetryPolicy(provider.RetryPolicy{MaxAttempts: 1})
+
+	var out bytes.Buffer
+	result, err := p.StreamChatContext(context.Background(), testMessages(), testTools(), &out)
+	if err != nil {
+		t.Fatalf("StreamChatContext: %v", err)
+	}
+
+	mu.Lock()
+	if gotPath != factory.Wire.Path() {
+		t.Errorf("request path = %q, want %q", gotPath, factory.Wire.Path())
+	}
+	if !strings.Contains(gotBody, "conformance check") {
+		t.Errorf("request body does not contain the user message: %s", gotBody)
+	}
+	mu.Unlock()
+
+	checkResult(t, factory.Wire, script, result)
+	checkEvents(t, factory.Wire, script, out.String())
+}
+
+func checkResult(t *testing.T, wire Wire, script Script, result *provider.StreamResult) {
+	t.Helper()
+
+	if result.Message.Role != "assistant" {
+		t.Errorf("message role = %q, want assistant", result.Message.Role)
+	}
+	if result.Message.Content != script.Text() {
+		t.Errorf("message content = %q, want %q", result.Message.Content, script.Text())
+	}
+	if result.FinishReason != script.FinishReason {
+		t.Errorf("finish reason = %q, want %q", result.FinishReason, script.FinishReason)
+	}
+	if result.Usage != script.Usage {
+		t.Errorf("usage = %+v, want %+v", result.Usage, script.Usage)
+	}
+
+	if len(result.Message.ToolCalls) != len(script.ToolCalls) {
+		t.Fatalf("got %d tool calls, want %d", len(result.Message.ToolCalls), len(script.ToolCalls))
+	}
+	seen := make(map[string]bool)
+	for i, want := range script.ToolCalls {
+		got := result.Message.ToolCalls[i]
+		if got.Function.Name != want.Name {
+			t.Errorf("tool call %d name = %q, want %q", i, got.Function.Name, want.Name)
+		}
+		if !sameJSON(got.Function.Arguments, want.Arguments()) {
+			t.Errorf("tool call %d arguments = %s, want %s", i, got.Function.Arguments, want.Arguments())
+		}
+		if wire.ToolCallIDs() && got.ID != want.ID {
+			t.Errorf("tool call %d id = %q, want %q", i, got.ID, want.ID)
+		}
+		if got.ID == "" || seen[got.ID] {
+			t.Errorf("tool call %d id %q is empty or not unique", i, got.ID)
+		}
+		seen[got.ID] = true
+	}
+}
+
+type event struct {
+	Type         string `json:"type"`
+	ID           string `json:"id"`
+	Delta        string `json:"delta"`
+	ToolCallID   string `json:"toolCallId"`
+	ToolName     string `json:"toolName"`
+	InputDelta   string `json:"inputTextDelta"`
+	Input        any    `json:"input"`
+	FinishReason string `json:"finishReason"`
+}
+
+// checkEvents verifies the UI message stream: the exact order of event
+// types (runs of deltas count once), that deltas add up to the full text and
+// arguments, and that [DONE] comes last.
+func checkEvents(t *testing.T, wire Wire, script Script, out string) {
+	t.Helper()
+
+	frames := strings.Split(strings.TrimSuffix(out, "\n\n"), "\n\n")
+	if len(frames) == 0 || frames[len(frames)-1] != "data: "+stream.EventDone {
+		t.Fatalf("stream does not end with [DONE]:\n%s", out)
+	}
+
+	var events []event
+	for _, frame := range frames[:len(frames)-1] {
+		data, ok := strings.CutPrefix(frame, "data: ")
+		if !ok {
+			t.Fatalf("malformed frame %q", frame)
+		}
+		var e event
+		if err := json.Unmarshal([]byte(data), &e); err != nil {
+			t.Fatalf("frame %q is not JSON: %v", frame, err)
+		}
+		events = append(events, e)
+	}
+
+	want := []string{stream.EventStart}
+	if len(script.TextChunks) > 0 {
+		want = append(want, stream.EventTextStart, stream.EventTextDelta, stream.EventTextEnd)
+	}
+	for range script.ToolCalls {
+		want = append(want, stream.EventToolInputStart, stream.EventToolInputDelta, stream.EventToolInputAvailable)
+	}
+	if len(script.TrailingTextChunks) > 0 {
+		want = append(want, stream.EventTextStart, stream.EventTextDelta, stream.EventTextEnd)
+	}
+	want = append(want, stream.EventFinish)
+
+	var got []string
+	for _, e := range events {
+		if n := len(got); n > 0 && got[n-1] == e.Type && (e.Type == stream.EventTextDelta || e.Type == stream.EventToolInputDelta) {
+			continue
+		}
+		got = append(got, e.Type)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("event order:\n got %v\nwant %v", got, want)
+	}
+
+	var text strings.Builder
+	args := make(map[string]*strings.Builder)
+	var callIDs []string
+	textParts := make(map[string]bool)
+	openText := ""
+	for _, e := range events {
+		switch e.Type {
+		case stream.EventTextStart:
+			if textParts[e.ID] {
+				t.Errorf("text part %q started twice", e.ID)
+			}
+			textParts[e.ID] = true
+			openText = e.ID
+		case stream.EventTextDelta:
+			if e.ID != openText {
+				t.Errorf("text-delta for %q, which is not the open text part", e.ID)
+			}
+			text.WriteString(e.Delta)
+		case stream.EventTextEnd:
+			if e.ID != openText {
+				t.Errorf("text-end for %q, which is not the open text part", e.ID)
+			}
+			openText = ""
+		case stream.EventToolInputStart:
+			args[e.ToolCallID] = &strings.Builder{}
+			callIDs = append(callIDs, e.ToolCallID)
+		case stream.EventToolInputDelta:
+			if b, ok := args[e.ToolCallID]; ok {
+				b.WriteString(e.InputDelta)
+			} else {
+				t.Errorf("tool-input-delta for unknown call %q", e.ToolCallID)
+			}
+		case stream.EventToolInputAvailable:
+			if len(callIDs) == 0 || callIDs[len(callIDs)-1] != e.ToolCallID {
+				t.Errorf("tool-input-available for %q does not follow its own deltas", e.ToolCallID)
+				continue
+			}
+			input, _ := json.Marshal(e.Input)
+			if !sameJSON(string(input), args[e.ToolCallID].String()) {
+				t.Errorf("tool-input-available input = %s, want %s", input, args[e.ToolCallID].String())
+			}
+		case stream.EventFinish:
+			if e.FinishReason != script.FinishReason.StreamReason() {
+				t.Errorf("finish reason = %q, want %q", e.FinishReason, script.FinishReason.StreamReason())
+			}
+		}
+	}
+
+	if text.String() != script.Text() {
+		t.Errorf("text deltas = %q, want %q", text.String(), script.Text())
+	}
+	for i, id := range callIDs {
+		want := script.ToolCalls[i]
+		if wire.ToolCallIDs() && id != want.ID {
+			t.Errorf("tool call %d streamed with id %q, want %q", i, id, want.ID)
+		}
+		if got := args[id].String(); !sameJSON(got, want.Arguments()) {
+			t.Errorf("tool call %d argument deltas = %s, want %s", i, got, want.Arguments())
+		}
+	}
+}
+
+func sameJSON(a, b string) bool {
+	var x, y any
+	if json.Unmarshal([]byte(a), &x) != nil || json.Unmarshal([]byte(b), &y) != nil {
+		return a == b
+	}
+	return reflect.DeepEqual(x, y)
+}
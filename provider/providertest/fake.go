@@ -0,0 +1,278 @@
+// Package providertest provides helpers for testing code built on
+// provider.Provider without calling a real model.
+package providertest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/alexisbouchez/palm/provider"
+	"github.com/alexisbouchez/palm/stream"
+)
+
+var ErrScriptExhausted = errors.New("fake provider has no scripted turns left")
+
+// Turn is the scripted reply to one provider call.
+type Turn struct {
+	Text         string
+	Reasoning    string
+	ToolCalls    []provider.ToolCall
+	FinishReason provider.FinishReason
+	Usage        provider.Usage
+	Err          error
+}
+
+func Text(text string) Turn {
+	return Turn{Text: text}
+}
+
+func ToolCall(name string, args any) Turn {
+	return Turn{}.WithToolCall(name, args)
+}
+
+func Fail(err error) Turn {
+	return Turn{Err: err}
+}
+
+// WithToolCall adds a call to the named tool. Args may be a JSON string or
+// any value that marshals to the arguments object.
+func (t Turn) WithToolCall(name string, args any) Turn {
+	var arguments string
+	switch a := args.(type) {
+	case string:
+		arguments = a
+	case nil:
+		arguments = "{}"
+	default:
+		b, err := json.Marshal(a)
+		if err != nil {
+			panic(fmt.Sprintf("providertest: marshal tool arguments: %v", err))
+		}
+		arguments = string(b)
+	}
+
+	t.ToolCalls = append(t.ToolCalls, provider.ToolCall{
+		Type:     "function",
+		Function: provider.FunctionCall{Name: name, Arguments: arguments},
+	})
+	return t
+}
+
+func (t Turn) WithReasoning(reasoning string) Turn {
+	t.Reasoning = reasoning
+	return t
+}
+
+func (t Turn) WithFinishReason(reason provider.FinishReason) Turn {
+	t.FinishReason = reason
+	return t
+}
+
+func (t Turn) WithUsage(usage provider.Usage) Turn {
+	t.Usage = usage
+	return t
+}
+
+// Request is what the fake received in one call.
+type Request struct {
+	Messages []provider.Message
+	Tools    []provider.Tool
+	Options  provider.GenerationOptions
+}
+
+// Fake is an in-process provider that answers each call with the next
+// scripted turn and records what it was sent. Streams follow the same event
+// order as the real providers.
+type Fake struct {
+	mu       sync.Mutex
+	turns    []Turn
+	requests []Request
+	options  provider.GenerationOptions
+	nextID   int
+}
+
+func NewFake(turns ...Turn) *Fake {
+	return &Fake{turns: turns}
+}
+
+// Script appends turns to the ones still to be played.
+func (f *Fake) Script(turns ...Turn) *Fake {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.turns = append(f.turns, turns...)
+	return f
+}
+
+func (f *Fake) Requests() []Request {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Request(nil), f.requests...)
+}
+
+// LastRequest returns the most recent request, or the zero Request if the
+// fake has not been called.
+func (f *Fake) LastRequest() Request {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.requests) == 0 {
+		return Request{}
+	}
+	return f.requests[len(f.requests)-1]
+}
+
+// Remaining returns the number of scripted turns not played yet.
+func (f *Fake) Remaining() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.turns)
+}
+
+func (f *Fake) WithAPIKey(key string) provider.Provider {
+	return f
+}
+
+func (f *Fake) WithModel(model string) provider.Provider {
+	return f
+}
+
+func (f *Fake) WithBaseURL(url string) provider.Provider {
+	return f
+}
+
+func (f *Fake) WithRetryPolicy(policy provider.RetryPolicy) provider.Provider {
+	return f
+}
+
+func (f *Fake) WithHTTPClient(client *http.Client) provider.Provider {
+	return f
+}
+
+func (f *Fake) WithGenerationOptions(opts provider.GenerationOptions) provider.Provider {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.options = opts
+	return f
+}
+
+// next records the request and pops the next turn, filling in tool call IDs
+// and the finish reason.
+func (f *Fake) next(ctx context.Context, messages []provider.Message, tools []provider.Tool) (Turn, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.requests = append(f.requests, Request{
+		Messages: append([]provider.Message(nil), messages...),
+		Tools:    append([]provider.Tool(nil), tools...),
+		Options:  f.options.Merge(provider.GenerationOptionsFromContext(ctx)),
+	})
+
+	if err := ctx.Err(); err != nil {
+		return Turn{}, err
+	}
+	if len(f.turns) == 0 {
+		return Turn{}, ErrScriptExhausted
+	}
+
+	turn := f.turns[0]
+	f.turns = f.turns[1:]
+
+	turn.ToolCalls = append([]provider.ToolCall(nil), turn.ToolCalls...)
+	for i := range turn.ToolCalls {
+		if turn.ToolCalls[i].ID == "" {
+			f.nextID++
+			turn.ToolCalls[i].ID = fmt.Sprintf("call_%d", f.nextID)
+		}
+	}
+
+	if turn.FinishReason == "" {
+		turn.FinishReason = provider.FinishReasonStop
+		if len(turn.ToolCalls) > 0 {
+			turn.FinishReason = provider.FinishReasonToolCalls
+		}
+	}
+
+	return turn, turn.Err
+}
+
+func (t Turn) message() provider.Message {
+	return provider.Message{
+		Role:      "assistant",
+		Content:   t.Text,
+		Reasoning: t.Reasoning,
+		ToolCalls: t.ToolCalls,
+	}
+}
+
+func (f *Fake) Chat(messages []provider.Message, tools []provider.Tool) (*provider.ChatResponse, error) {
+	return f.ChatContext(context.Background(), messages, tools)
+}
+
+func (f *Fake) ChatContext(ctx context.Context, messages []provider.Message, tools []provider.Tool) (*provider.ChatResponse, error) {
+	turn, err := f.next(ctx, messages, tools)
+	if err != nil {
+		return nil, err
+	}
+
+	return &provider.ChatResponse{
+		ID: "fake",
+		Choices: []provider.Choice{{
+			Message:      turn.message(),
+			FinishReason: turn.FinishReason,
+		}},
+		Usage: turn.Usage,
+	}, nil
+}
+
+func (f *Fake) StreamChat(messages []provider.Message, tools []provider.Tool, writer io.Writer) (*provider.StreamResult, error) {
+	return f.StreamChatContext(context.Background(), messages, tools, writer)
+}
+
+func (f *Fake) StreamChatContext(ctx context.Context, messages []provider.Message, tools []provider.Tool, writer io.Writer) (*provider.StreamResult, error) {
+	turn, err := f.next(ctx, messages, tools)
+	if err != nil {
+		return nil, err
+	}
+
+	emitter := stream.NewEmitter(writer)
+	emitter.Start("msg_fake")
+
+	if turn.Reasoning != "" {
+		emitter.ReasoningStart("reasoning_fake")
+		emitter.ReasoningDelta("reasoning_fake", turn.Reasoning)
+		emitter.ReasoningEnd("reasoning_fake")
+	}
+
+	if turn.Text != "" {
+		emitter.TextStart("text_fake")
+		for _, word := range strings.SplitAfter(turn.Text, " ") {
+			emitter.TextDelta("text_fake", word)
+		}
+		emitter.TextEnd("text_fake")
+	}
+
+	for _, tc := range turn.ToolCalls {
+		emitter.ToolInputStart(tc.ID, tc.Function.Name)
+		emitter.ToolInputDelta(tc.ID, tc.Function.Arguments)
+
+		var input any
+		if err := json.Unmarshal([]byte(tc.Function.Arguments), &input); err != nil {
+			input = tc.Function.Arguments
+		}
+		emitter.ToolInputAvailable(tc.ID, tc.Function.Name, input)
+	}
+
+	emitter.Finish(turn.FinishReason.StreamReason(), map[string]any{"usage": turn.Usage})
+	emitter.Done()
+
+	return &provider.StreamResult{
+		Message:      turn.message(),
+		Usage:        turn.Usage,
+		FinishReason: turn.FinishReason,
+	}, nil
+}
@@ -0,0 +1,235 @@
+package providertest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/alexisbouchez/palm/provider"
+)
+
+// Script is a model reply that a Wire encodes in its vendor's format.
+// TrailingTextChunks arrive after the tool calls.
+type Script struct {
+	TextChunks         []string
+	ToolCalls          []ScriptedToolCall
+	TrailingTextChunks []string
+	FinishReason       provider.FinishReason
+	Usage              provider.Usage
+}
+
+// ScriptedToolCall is a tool call whose arguments arrive in chunks.
+type ScriptedToolCall struct {
+	ID             string
+	Name           string
+	ArgumentChunks []string
+}
+
+func (c ScriptedToolCall) Arguments() string {
+	return strings.Join(c.ArgumentChunks, "")
+}
+
+func (s Script) Text() string {
+	return strings.Join(s.TextChunks, "") + strings.Join(s.TrailingTextChunks, "")
+}
+
+// Wire speaks one vendor's chat API on the test server.
+type Wire interface {
+	// Path is the chat endpoint, relative to the base URL given to the
+	// provider.
+	Path() string
+	WriteStream(w http.ResponseWriter, s Script)
+	// ToolCallIDs reports whether the vendor sends tool call IDs, as
+	// opposed to the provider generating them.
+	ToolCallIDs() bool
+}
+
+var (
+	OpenAIWire    Wire = openAIWire{}
+	AnthropicWire Wire = anthropicWire{}
+	OllamaWire    Wire = ollamaWire{}
+)
+
+func writeSSE(w http.ResponseWriter, event string, data any) {
+	b, _ := json.Marshal(data)
+	if event != "" {
+		fmt.Fprintf(w, "event: %s\n", event)
+	}
+	fmt.Fprintf(w, "data: %s\n\n", b)
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// openAIWire is the chat completions format shared by OpenAI, Mistral and
+// most compatible servers.
+type openAIWire struct{}
+
+func (openAIWire) Path() string      { return "/chat/completions" }
+func (openAIWire) ToolCallIDs() bool { return true }
+
+func (openAIWire) WriteStream(w http.ResponseWriter, s Script) {
+	w.Header().Set("Content-Type", "text/event-stream")
+
+	chunk := func(delta map[string]any, finishReason any) map[string]any {
+		return map[string]any{
+			"id":      "chatcmpl-test",
+			"object":  "chat.completion.chunk",
+			"model":   "test",
+			"choices": []map[string]any{{"index": 0, "delta": delta, "finish_reason": finishReason}},
+		}
+	}
+
+	writeSSE(w, "", chunk(map[string]any{"role": "assistant", "content": ""}, nil))
+	for _, text := range s.TextChunks {
+		writeSSE(w, "", chunk(map[string]any{"content": text}, nil))
+	}
+	for i, tc := range s.ToolCalls {
+		for j, args := range tc.ArgumentChunks {
+			call := map[string]any{"index": i, "function": map[string]any{"arguments": args}}
+			if j == 0 {
+				call["id"] = tc.ID
+				call["type"] = "function"
+				call["function"].(map[string]any)["name"] = tc.Name
+			}
+			writeSSE(w, "", chunk(map[string]any{"tool_calls": []any{call}}, nil))
+		}
+	}
+	for _, text := range s.TrailingTextChunks {
+		writeSSE(w, "", chunk(map[string]any{"content": text}, nil))
+	}
+
+	finish := map[string]any{
+		"id":      "chatcmpl-test",
+		"object":  "chat.completion.chunk",
+		"model":   "test",
+		"choices": []map[string]any{{"index": 0, "delta": map[string]any{}, "finish_reason": string(s.FinishReason)}},
+		"usage":   s.Usage,
+	}
+	writeSSE(w, "", finish)
+	fmt.Fprint(w, "data: [DONE]\n\n")
+}
+
+type anthropicWire struct{}
+
+func (anthropicWire) Path() string      { return "/messages" }
+func (anthropicWire) ToolCallIDs() bool { return true }
+
+func (anthropicWire) WriteStream(w http.ResponseWriter, s Script) {
+	w.Header().Set("Content-Type", "text/event-stream")
+
+	writeSSE(w, "message_start", map[string]any{
+		"type": "message_start",
+		"message": map[string]any{
+			"id":      "msg_test",
+			"role":    "assistant",
+			"content": []any{},
+			"usage":   map[string]any{"input_tokens": s.Usage.PromptTokens, "output_tokens": 1},
+		},
+	})
+
+	index := 0
+	textBlock := func(chunks []string) {
+		if len(chunks) == 0 {
+			return
+		}
+		writeSSE(w, "content_block_start", map[string]any{
+			"type":          "content_block_start",
+			"index":         index,
+			"content_block": map[string]any{"type": "text", "text": ""},
+		})
+		for _, text := range chunks {
+			writeSSE(w, "content_block_delta", map[string]any{
+				"type":  "content_block_delta",
+				"index": index,
+				"delta": map[string]any{"type": "text_delta", "text": text},
+			})
+		}
+		writeSSE(w, "content_block_stop", map[string]any{"type": "content_block_stop", "index": index})
+		index++
+	}
+
+	textBlock(s.TextChunks)
+
+	for _, tc := range s.ToolCalls {
+		writeSSE(w, "content_block_start", map[string]any{
+			"type":          "content_block_start",
+			"index":         index,
+			"content_block": map[string]any{"type": "tool_use", "id": tc.ID, "name": tc.Name, "input": map[string]any{}},
+		})
+		for _, args := range tc.ArgumentChunks {
+			writeSSE(w, "content_block_delta", map[string]any{
+				"type":  "content_block_delta",
+				"index": index,
+				"delta": map[string]any{"type": "input_json_delta", "partial_json": args},
+			})
+		}
+		writeSSE(w, "content_block_stop", map[string]any{"type": "content_block_stop", "index": index})
+		index++
+	}
+	textBlock(s.TrailingTextChunks)
+
+	stopReason := "end_turn"
+	switch s.FinishReason {
+	case provider.FinishReasonToolCalls:
+		stopReason = "tool_use"
+	case provider.FinishReasonLength:
+		stopReason = "max_tokens"
+	}
+	writeSSE(w, "message_delta", map[string]any{
+		"type":  "message_delta",
+		"delta": map[string]any{"stop_reason": stopReason},
+		"usage": map[string]any{"output_tokens": s.Usage.CompletionTokens},
+	})
+	writeSSE(w, "message_stop", map[string]any{"type": "message_stop"})
+}
+
+// ollamaWire streams newline-delimited JSON. Ollama sends each tool call
+// whole, so argument chunks are joined.
+type ollamaWire struct{}
+
+func (ollamaWire) Path() string      { return "/api/chat" }
+func (ollamaWire) ToolCallIDs() bool { return false }
+
+func (ollamaWire) WriteStream(w http.ResponseWriter, s Script) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+
+	message := func(content string, toolCalls []any) map[string]any {
+		m := map[string]any{"role": "assistant", "content": content}
+		if toolCalls != nil {
+			m["tool_calls"] = toolCalls
+		}
+		return m
+	}
+
+	for _, text := range s.TextChunks {
+		enc.Encode(map[string]any{"model": "test", "message": message(text, nil), "done": false})
+	}
+	if len(s.ToolCalls) > 0 {
+		var calls []any
+		for _, tc := range s.ToolCalls {
+			calls = append(calls, map[string]any{
+				"function": map[string]any{"name": tc.Name, "arguments": json.RawMessage(tc.Arguments())},
+			})
+		}
+		enc.Encode(map[string]any{"model": "test", "message": message("", calls), "done": false})
+	}
+	for _, text := range s.TrailingTextChunks {
+		enc.Encode(map[string]any{"model": "test", "message": message(text, nil), "done": false})
+	}
+
+	doneReason := "stop"
+	if s.FinishReason == provider.FinishReasonLength {
+		doneReason = "length"
+	}
+	enc.Encode(map[string]any{
+		"model":             "test",
+		"message":           message("", nil),
+		"done":              true,
+		"done_reason":       doneReason,
+		"prompt_eval_count": s.Usage.PromptTokens,
+		"eval_count":        s.Usage.CompletionTokens,
+	})
+}
@@ -0,0 +1,75 @@
+package provider
+
+import "strings"
+
+const (
+	thinkOpenTag  = "<think>"
+	thinkCloseTag = "</think>"
+)
+
+// Segment is a run of streamed text that is either reasoning or answer.
+type Segment struct {
+	Reasoning bool
+	Text      string
+}
+
+// ThinkTagParser splits streamed content into reasoning and answer text for
+// models that wrap their reasoning in <think> tags. Tags may be split across
+// deltas, so text that could be the start of a tag is held back until the
+// next call to Feed or Flush.
+type ThinkTagParser struct {
+	reasoning bool
+	pending   string
+}
+
+func (p *ThinkTagParser) Feed(delta string) []Segment {
+	var segments []Segment
+	text := p.pending + delta
+	p.pending = ""
+
+	for text != "" {
+		tag := thinkOpenTag
+		if p.reasoning {
+			tag = thinkCloseTag
+		}
+
+		if idx := strings.Index(text, tag); idx != -1 {
+			segments = p.appendSegment(segments, text[:idx])
+			p.reasoning = !p.reasoning
+			text = text[idx+len(tag):]
+			continue
+		}
+
+		keep := partialTagLen(text, tag)
+		segments = p.appendSegment(segments, text[:len(text)-keep])
+		p.pending = text[len(text)-keep:]
+		break
+	}
+
+	return segments
+}
+
+// Flush returns any text held back by Feed.
+func (p *ThinkTagParser) Flush() []Segment {
+	text := p.pending
+	p.pending = ""
+	return p.appendSegment(nil, text)
+}
+
+func (p *ThinkTagParser) appendSegment(segments []Segment, text string) []Segment {
+	if text == "" {
+		return segments
+	}
+	return append(segments, Segment{Reasoning: p.reasoning, Text: text})
+}
+
+// partialTagLen returns the length of the longest suffix of text that is a
+// prefix of tag.
+func partialTagLen(text, tag string) int {
+	for n := min(len(text), len(tag)-1); n > 0; n-- {
+		if strings.HasSuffix(text, tag[:n]) {
+			return n
+		}
+	}
+	return 0
+}
@@ -0,0 +1,157 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type APIError struct {
+	StatusCode int
+	Body       string
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("api error %d: %s", e.StatusCode, e.Body)
+}
+
+func (e *APIError) Retryable() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+var contextWindowPhrases = []string{
+	"context length",
+	"context window",
+	"context_length_exceeded",
+	"maximum context",
+	"too many tokens",
+	"prompt is too long",
+}
+
+func IsContextWindowError(err error) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode < 400 || apiErr.StatusCode >= 500 {
+		return false
+	}
+	body := strings.ToLower(apiErr.Body)
+	for _, phrase := range contextWindowPhrases {
+		if strings.Contains(body, phrase) {
+			return true
+		}
+	}
+	return false
+}
+
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	Jitter         float64
+}
+
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: 500 * time.Millisecond,
+		MaxBackoff:     30 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+	}
+}
+
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := float64(p.InitialBackoff)
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	for i := 1; i < attempt; i++ {
+		d *= multiplier
+	}
+	if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		d -= d * p.Jitter * rand.Float64()
+	}
+	return time.Duration(d)
+}
+
+// Do sends the request built by newRequest, retrying connection errors, 429
+// and 5xx responses. It only ever retries before a successful response is
+// returned, so nothing has been streamed to the caller yet. Headers added
+// with ContextWithHeaders are set on every attempt.
+func (p RetryPolicy) Do(ctx context.Context, client *http.Client, newRequest func() (*http.Request, error)) (*http.Response, error) {
+	attempts := max(p.MaxAttempts, 1)
+
+	for attempt := 1; ; attempt++ {
+		req, err := newRequest()
+		if err != nil {
+			return nil, err
+		}
+		for key, values := range HeadersFromContext(ctx) {
+			req.Header[key] = values
+		}
+
+		var wait time.Duration
+		resp, err := client.Do(req)
+		switch {
+		case err != nil:
+			if ctx.Err() != nil || attempt >= attempts {
+				return nil, fmt.Errorf("do request: %w", err)
+			}
+			wait = p.backoff(attempt)
+			slog.Warn("retrying request", "attempt", attempt, "wait", wait, "error", err)
+
+		case resp.StatusCode == http.StatusOK:
+			return resp, nil
+
+		default:
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			apiErr := &APIError{
+				StatusCode: resp.StatusCode,
+				Body:       string(body),
+				RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+			}
+			if !apiErr.Retryable() || attempt >= attempts {
+				return nil, apiErr
+			}
+			wait = max(p.backoff(attempt), apiErr.RetryAfter)
+			slog.Warn("retrying request", "attempt", attempt, "wait", wait, "status", resp.StatusCode)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
@@ -0,0 +1,189 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var fastRetry = RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, Multiplier: 2}
+
+// statusServer answers with the given statuses in turn, then 200, and counts
+// the requests it gets.
+func statusServer(t *testing.T, header http.Header, statuses ...int) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(requests.Add(1))
+		if n <= len(statuses) {
+			for key, values := range header {
+				w.Header()[key] = values
+			}
+			http.Error(w, "failure", statuses[n-1])
+			return
+		}
+		io.WriteString(w, "ok")
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &requests
+}
+
+func get(ctx context.Context, url string) func() (*http.Request, error) {
+	return func() (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	}
+}
+
+func TestRetryGivesUpAfterMaxAttempts(t *testing.T) {
+	srv, requests := statusServer(t, nil, 503, 503, 503, 503)
+
+	_, err := fastRetry.Do(context.Background(), http.DefaultClient, get(context.Background(), srv.URL))
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("err = %v, want a 503 APIError", err)
+	}
+	if n := requests.Load(); n != 3 {
+		t.Errorf("sent %d requests, want 3", n)
+	}
+}
+
+func TestRetrySucceedsAfterRetryableStatus(t *testing.T) {
+	srv, requests := statusServer(t, nil, http.StatusTooManyRequests, http.StatusBadGateway)
+
+	resp, err := fastRetry.Do(context.Background(), http.DefaultClient, get(context.Background(), srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if n := requests.Load(); n != 3 {
+		t.Errorf("sent %d requests, want 3", n)
+	}
+}
+
+func TestRetrySkipsClientErrors(t *testing.T) {
+	for _, status := range []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusNotFound} {
+		srv, requests := statusServer(t, nil, status)
+
+		_, err := fastRetry.Do(context.Background(), http.DefaultClient, get(context.Background(), srv.URL))
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) || apiErr.StatusCode != status {
+			t.Errorf("status %d: err = %v", status, err)
+		}
+		if n := requests.Load(); n != 1 {
+			t.Errorf("status %d: sent %d requests, want 1", status, n)
+		}
+	}
+}
+
+func TestRetryResendsBody(t *testing.T) {
+	body := []byte(`{"model":"test"}`)
+	var bodies []string
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+		if requests.Add(1) < 3 {
+			http.Error(w, "failure", http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	resp, err := fastRetry.Do(context.Background(), http.DefaultClient, func() (*http.Request, error) {
+		return http.NewRequest(http.MethodPost, srv.URL, bytes.NewReader(body))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if len(bodies) != 3 {
+		t.Fatalf("sent %d requests, want 3", len(bodies))
+	}
+	for i, b := range bodies {
+		if b != string(body) {
+			t.Errorf("attempt %d sent %q, want %q", i+1, b, body)
+		}
+	}
+}
+
+func TestRetryAfterHeader(t *testing.T) {
+	for _, value := range []string{"7", time.Now().Add(7 * time.Second).UTC().Format(http.TimeFormat)} {
+		srv, _ := statusServer(t, http.Header{"Retry-After": {value}}, http.StatusTooManyRequests)
+
+		_, err := RetryPolicy{MaxAttempts: 1}.Do(context.Background(), http.DefaultClient, get(context.Background(), srv.URL))
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) {
+			t.Fatalf("Retry-After %q: err = %v", value, err)
+		}
+		if apiErr.RetryAfter < 5*time.Second || apiErr.RetryAfter > 7*time.Second {
+			t.Errorf("Retry-After %q: parsed %v, want about 7s", value, apiErr.RetryAfter)
+		}
+	}
+
+	for _, value := range []string{"", "soon", "-3", time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)} {
+		if d := parseRetryAfter(value); d != 0 {
+			t.Errorf("parseRetryAfter(%q) = %v, want 0", value, d)
+		}
+	}
+}
+
+// The wait honors Retry-After over the much shorter backoff, and a cancelled
+// context ends it right away.
+func TestRetryWaitStopsOnCancel(t *testing.T) {
+	srv, requests := statusServer(t, http.Header{"Retry-After": {"30"}}, http.StatusTooManyRequests)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := fastRetry.Do(ctx, http.DefaultClient, get(ctx, srv.URL))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("returned after %v, want promptly after the cancel", elapsed)
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("sent %d requests, want 1", n)
+	}
+}
+
+func TestRetryConnectionErrors(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	url := srv.URL
+	srv.Close()
+
+	var attempts int
+	_, err := fastRetry.Do(context.Background(), http.DefaultClient, func() (*http.Request, error) {
+		attempts++
+		return http.NewRequest(http.MethodGet, url, nil)
+	})
+	if err == nil {
+		t.Fatal("want an error from a closed server")
+	}
+	if attempts != 3 {
+		t.Errorf("made %d attempts, want 3", attempts)
+	}
+}
+
+func TestBackoffGrowsAndCaps(t *testing.T) {
+	p := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: 300 * time.Millisecond, Multiplier: 2}
+	for attempt, want := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 3: 300 * time.Millisecond, 5: 300 * time.Millisecond} {
+		if got := p.backoff(attempt); got != want {
+			t.Errorf("backoff(%d) = %v, want %v", attempt, got, want)
+		}
+	}
+
+	p.Jitter = 0.5
+	for range 20 {
+		if got := p.backoff(1); got < 50*time.Millisecond || got > 100*time.Millisecond {
+			t.Errorf("jittered backoff = %v, want between 50ms and 100ms", got)
+		}
+	}
+}
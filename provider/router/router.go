@@ -0,0 +1,197 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/alexisbouchez/palm/provider"
+	"github.com/alexisbouchez/palm/stream"
+)
+
+type Backend struct {
+	Name     string
+	Provider provider.Provider
+}
+
+type router struct {
+	backends []Backend
+}
+
+var ErrNoBackends = errors.New("no backends configured")
+
+func New(backends ...Backend) provider.Provider {
+	r := &router{}
+	for i, b := range backends {
+		if b.Name == "" {
+			b.Name = fmt.Sprintf("backend-%d", i)
+		}
+		r.backends = append(r.backends, b)
+	}
+	return r
+}
+
+// WithAPIKey, WithModel and WithBaseURL configure the primary backend only;
+// fallbacks are expected to be configured before they are passed to New.
+func (r *router) WithAPIKey(key string) provider.Provider {
+	if len(r.backends) > 0 {
+		r.backends[0].Provider.WithAPIKey(key)
+	}
+	return r
+}
+
+func (r *router) WithModel(model string) provider.Provider {
+	if len(r.backends) > 0 {
+		r.backends[0].Provider.WithModel(model)
+	}
+	return r
+}
+
+func (r *router) WithBaseURL(url string) provider.Provider {
+	if len(r.backends) > 0 {
+		r.backends[0].Provider.WithBaseURL(url)
+	}
+	return r
+}
+
+func (r *router) WithRetryPolicy(policy provider.RetryPolicy) provider.Provider {
+	for _, b := range r.backends {
+		b.Provider.WithRetryPolicy(policy)
+	}
+	return r
+}
+
+func (r *router) WithHTTPClient(client *http.Client) provider.Provider {
+	for _, b := range r.backends {
+		b.Provider.WithHTTPClient(client)
+	}
+	return r
+}
+
+func (r *router) WithGenerationOptions(opts provider.GenerationOptions) provider.Provider {
+	for _, b := range r.backends {
+		b.Provider.WithGenerationOptions(opts)
+	}
+	return r
+}
+
+func shouldFailover(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	var apiErr *provider.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Retryable() || provider.IsContextWindowError(err)
+	}
+
+	var urlErr *url.Error
+	var netErr net.Error
+	return errors.As(err, &urlErr) || errors.As(err, &netErr)
+}
+
+func (r *router) Chat(messages []provider.Message, tools []provider.Tool) (*provider.ChatResponse, error) {
+	return r.ChatContext(context.Background(), messages, tools)
+}
+
+func (r *router) ChatContext(ctx context.Context, messages []provider.Message, tools []provider.Tool) (*provider.ChatResponse, error) {
+	if len(r.backends) == 0 {
+		return nil, ErrNoBackends
+	}
+
+	var errs []error
+	for _, b := range r.backends {
+		resp, err := b.Provider.ChatContext(ctx, messages, tools)
+		if err == nil {
+			return resp, nil
+		}
+
+		errs = append(errs, fmt.Errorf("%s: %w", b.Name, err))
+		if !shouldFailover(ctx, err) {
+			break
+		}
+		slog.Warn("provider failed, trying next backend", "backend", b.Name, "error", err)
+	}
+
+	return nil, errors.Join(errs...)
+}
+
+func (r *router) StreamChat(messages []provider.Message, tools []provider.Tool, writer io.Writer) (*provider.StreamResult, error) {
+	return r.StreamChatContext(context.Background(), messages, tools, writer)
+}
+
+func (r *router) StreamChatContext(ctx context.Context, messages []provider.Message, tools []provider.Tool, writer io.Writer) (*provider.StreamResult, error) {
+	if len(r.backends) == 0 {
+		return nil, ErrNoBackends
+	}
+
+	var errs []error
+	for _, b := range r.backends {
+		w := &backendWriter{writer: writer, backend: b.Name}
+		result, err := b.Provider.StreamChatContext(ctx, messages, tools, w)
+		if err == nil {
+			return result, nil
+		}
+
+		errs = append(errs, fmt.Errorf("%s: %w", b.Name, err))
+		// Once a backend has streamed events to the client there is no way
+		// to take them back, so the turn fails instead of switching over.
+		if w.written || !shouldFailover(ctx, err) {
+			break
+		}
+		slog.Warn("provider failed, trying next backend", "backend", b.Name, "error", err)
+	}
+
+	return nil, errors.Join(errs...)
+}
+
+// backendWriter records whether the backend wrote anything and injects a
+// message-metadata event naming the backend right after the start event.
+type backendWriter struct {
+	writer   io.Writer
+	backend  string
+	written  bool
+	injected bool
+	pending  []byte
+}
+
+func (w *backendWriter) Write(p []byte) (int, error) {
+	w.written = true
+	if w.injected {
+		return w.writer.Write(p)
+	}
+
+	w.pending = append(w.pending, p...)
+	idx := bytes.Index(w.pending, []byte("\n\n"))
+	if idx == -1 {
+		return len(p), nil
+	}
+
+	w.injected = true
+	if _, err := w.writer.Write(w.pending[:idx+2]); err != nil {
+		return 0, err
+	}
+	if err := stream.NewEmitter(w.writer).MessageMetadata(map[string]any{"backend": w.backend}); err != nil {
+		return 0, err
+	}
+	if rest := w.pending[idx+2:]; len(rest) > 0 {
+		if _, err := w.writer.Write(rest); err != nil {
+			return 0, err
+		}
+	}
+	w.pending = nil
+
+	return len(p), nil
+}
+
+func (w *backendWriter) Flush() {
+	if f, ok := w.writer.(http.Flusher); ok {
+		f.Flush()
+	}
+}
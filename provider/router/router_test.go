@@ -0,0 +1,149 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/alexisbouchez/palm/provider"
+	"github.com/alexisbouchez/palm/provider/providertest"
+	"github.com/alexisbouchez/palm/stream"
+)
+
+func messages() []provider.Message {
+	return []provider.Message{{Role: "user", Content: "hi"}}
+}
+
+func TestFailover(t *testing.T) {
+	failures := map[string]error{
+		"5xx":            &provider.APIError{StatusCode: http.StatusBadGateway, Body: "bad gateway"},
+		"429":            &provider.APIError{StatusCode: http.StatusTooManyRequests, Body: "slow down"},
+		"context window": &provider.APIError{StatusCode: http.StatusBadRequest, Body: "maximum context length is 32768 tokens"},
+		"connection":     &url.Error{Op: "Post", URL: "http://primary", Err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}},
+	}
+
+	for name, failure := range failures {
+		t.Run(name, func(t *testing.T) {
+			primary := providertest.NewFake(providertest.Fail(failure), providertest.Fail(failure))
+			fallback := providertest.NewFake(providertest.Text("from fallback"), providertest.Text("from fallback"))
+			r := New(Backend{Name: "primary", Provider: primary}, Backend{Name: "fallback", Provider: fallback})
+
+			var out bytes.Buffer
+			result, err := r.StreamChatContext(context.Background(), messages(), nil, &out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Message.Content != "from fallback" {
+				t.Errorf("content = %q", result.Message.Content)
+			}
+
+			resp, err := r.ChatContext(context.Background(), messages(), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.Choices[0].Message.Content != "from fallback" {
+				t.Errorf("chat content = %q", resp.Choices[0].Message.Content)
+			}
+		})
+	}
+}
+
+func TestNoFailoverOnClientError(t *testing.T) {
+	failure := &provider.APIError{StatusCode: http.StatusUnauthorized, Body: "invalid api key"}
+	primary := providertest.NewFake(providertest.Fail(failure))
+	fallback := providertest.NewFake(providertest.Text("from fallback"))
+	r := New(Backend{Provider: primary}, Backend{Provider: fallback})
+
+	_, err := r.StreamChatContext(context.Background(), messages(), nil, io.Discard)
+	var apiErr *provider.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("err = %v, want the 401", err)
+	}
+	if !strings.Contains(err.Error(), "backend-0") {
+		t.Errorf("err = %v, want the backend name", err)
+	}
+	if fallback.Remaining() != 1 {
+		t.Error("fallback was called after a non-retryable error")
+	}
+}
+
+func TestNoFailoverOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	fallback := providertest.NewFake(providertest.Text("from fallback"))
+	r := New(Backend{Provider: providertest.NewFake(providertest.Fail(context.Canceled))}, Backend{Provider: fallback})
+
+	if _, err := r.StreamChatContext(ctx, messages(), nil, io.Discard); !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+	if fallback.Remaining() != 1 {
+		t.Error("fallback was called after the context was cancelled")
+	}
+}
+
+// failsMidStream writes the start of a reply and then fails with a
+// retryable error.
+type failsMidStream struct {
+	provider.Provider
+}
+
+func (failsMidStream) StreamChatContext(ctx context.Context, messages []provider.Message, tools []provider.Tool, writer io.Writer) (*provider.StreamResult, error) {
+	emitter := stream.NewEmitter(writer)
+	emitter.Start("msg_partial")
+	emitter.TextStart("text_partial")
+	return nil, &provider.APIError{StatusCode: http.StatusBadGateway, Body: "connection reset"}
+}
+
+func TestNoFailoverAfterStreaming(t *testing.T) {
+	fallback := providertest.NewFake(providertest.Text("from fallback"))
+	r := New(Backend{Provider: failsMidStream{}}, Backend{Provider: fallback})
+
+	var out bytes.Buffer
+	if _, err := r.StreamChatContext(context.Background(), messages(), nil, &out); err == nil {
+		t.Fatal("want the primary's error")
+	}
+	if fallback.Remaining() != 1 {
+		t.Error("fallback was called after the primary streamed events")
+	}
+	if strings.Contains(out.String(), "from fallback") {
+		t.Errorf("fallback output was mixed into the stream:\n%s", out.String())
+	}
+}
+
+func TestBackendMetadataFollowsStart(t *testing.T) {
+	primary := providertest.NewFake(providertest.Fail(&provider.APIError{StatusCode: http.StatusServiceUnavailable}))
+	fallback := providertest.NewFake(providertest.Text("hello"))
+	r := New(Backend{Name: "mistral", Provider: primary}, Backend{Name: "local", Provider: fallback})
+
+	var out bytes.Buffer
+	if _, err := r.StreamChatContext(context.Background(), messages(), nil, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	frames := strings.Split(out.String(), "\n\n")
+	if len(frames) < 3 {
+		t.Fatalf("too few frames:\n%s", out.String())
+	}
+	if !strings.Contains(frames[0], `"type":"start"`) {
+		t.Errorf("first frame = %s, want start", frames[0])
+	}
+	if want := `data: {"messageMetadata":{"backend":"local"},"type":"message-metadata"}`; frames[1] != want {
+		t.Errorf("second frame = %s, want %s", frames[1], want)
+	}
+	if n := strings.Count(out.String(), `"message-metadata"`); n != 1 {
+		t.Errorf("got %d message-metadata frames, want 1", n)
+	}
+}
+
+func TestNoBackends(t *testing.T) {
+	if _, err := New().StreamChatContext(context.Background(), messages(), nil, io.Discard); !errors.Is(err, ErrNoBackends) {
+		t.Errorf("err = %v, want ErrNoBackends", err)
+	}
+}
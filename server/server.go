@@ -1,36 +1,156 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/alexisbouchez/palm/agent"
 	"github.com/alexisbouchez/palm/provider"
+	"github.com/alexisbouchez/palm/store"
 	"github.com/alexisbouchez/palm/tool"
 )
 
-type Server interface{
+type Server interface {
+	WithInstructions(instructions string) Server
+	WithInstructionsFunc(fn agent.InstructionsFunc) Server
+	WithToolConcurrency(n int) Server
+	WithConversationStore(store store.ConversationStore) Server
 	Start(addr string) error
 }
 
 type server struct {
-	provider provider.Provider
-	tools    []tool.Callable
+	provider     provider.Provider
+	tools        []tool.Callable
+	instructions agent.InstructionsFunc
+	concurrency  int
+	store        store.ConversationStore
+
+	mu      sync.Mutex
+	pending map[string]*pendingChat
+	locks   map[string]*conversationLock
+}
+
+// pendingChat is an agent whose turn is waiting for the client to approve
+// tool calls, indexed by each of its approval IDs.
+type pendingChat struct {
+	agent        agent.Agent
+	conversation string
+	expires      time.Time
 }
 
+// conversationLock serializes the requests of one conversation, so each one
+// loads the history the previous one saved.
+type conversationLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+const approvalTimeout = 30 * time.Minute
+
+// ChatRequest either starts a turn with a message or, when Approvals is set,
+// resumes a turn that ended with tool-approval-request events. ID names the
+// conversation the message belongs to.
 type ChatRequest struct {
-	Message string `json:"message"`
+	ID        string             `json:"id,omitempty"`
+	Message   string             `json:"message"`
+	Parts     []MessagePart      `json:"parts,omitempty"`
+	Approvals []ApprovalResponse `json:"approvals,omitempty"`
+}
+
+// ApprovalResponse answers one tool-approval-request event.
+type ApprovalResponse struct {
+	ID       string `json:"id"`
+	Approved bool   `json:"approved"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// MessagePart mirrors the text and file parts of an AI SDK UI message. File
+// URLs may be data URLs holding the uploaded bytes.
+type MessagePart struct {
+	Type      string `json:"type"`
+	Text      string `json:"text,omitempty"`
+	MediaType string `json:"mediaType,omitempty"`
+	Filename  string `json:"filename,omitempty"`
+	URL       string `json:"url,omitempty"`
+}
+
+func (req ChatRequest) toMessage() (provider.Message, error) {
+	msg := provider.Message{Role: "user", Content: req.Message}
+	if len(req.Parts) == 0 {
+		return msg, nil
+	}
+
+	if req.Message != "" {
+		msg.Parts = append(msg.Parts, provider.TextPart(req.Message))
+	}
+	for _, part := range req.Parts {
+		switch part.Type {
+		case "text":
+			msg.Parts = append(msg.Parts, provider.TextPart(part.Text))
+		case "file":
+			if mediaType, data, ok := provider.ParseDataURL(part.URL); ok {
+				if part.MediaType != "" {
+					mediaType = part.MediaType
+				}
+				p := provider.DataPart(mediaType, data)
+				p.Name = part.Filename
+				msg.Parts = append(msg.Parts, p)
+			} else if strings.HasPrefix(part.MediaType, "image/") {
+				msg.Parts = append(msg.Parts, provider.ImageURLPart(part.URL))
+			} else {
+				msg.Parts = append(msg.Parts, provider.DocumentURLPart(part.URL, part.Filename))
+			}
+		default:
+			return provider.Message{}, fmt.Errorf("unsupported part type %q", part.Type)
+		}
+	}
+	msg.Content = ""
+
+	return msg, nil
 }
 
 func New(provider provider.Provider, tools []tool.Callable) Server {
 	return &server{
 		provider: provider,
 		tools:    tools,
+		pending:  map[string]*pendingChat{},
+		locks:    map[string]*conversationLock{},
 	}
 }
 
+func (s *server) WithInstructions(instructions string) Server {
+	return s.WithInstructionsFunc(func(context.Context, []provider.Tool) (string, error) {
+		return instructions, nil
+	})
+}
+
+// WithInstructionsFunc renders the system prompt for each request; the
+// request context is passed through, so it can depend on the caller.
+func (s *server) WithInstructionsFunc(fn agent.InstructionsFunc) Server {
+	s.instructions = fn
+	return s
+}
+
+// WithToolConcurrency sets how many tool calls of one step may run at once.
+func (s *server) WithToolConcurrency(n int) Server {
+	s.concurrency = n
+	return s
+}
+
+// WithConversationStore keeps the history of each conversation that a request
+// names by ID, so follow-up messages have the earlier context.
+func (s *server) WithConversationStore(store store.ConversationStore) Server {
+	s.store = store
+	return s
+}
+
 func (s *server) handleChat(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -44,7 +164,47 @@ func (s *server) handleChat(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	slog.Info("handling chat request", "message", req.Message)
+	var agt agent.Agent
+	var run func() error
+	if len(req.Approvals) > 0 {
+		var unlock func()
+		agt, unlock = s.takePending(req.Approvals[0].ID)
+		if agt == nil {
+			http.Error(w, "Unknown or expired approval", http.StatusNotFound)
+			return
+		}
+		defer unlock()
+
+		approvals := make(map[string]agent.Approval, len(req.Approvals))
+		for _, a := range req.Approvals {
+			approvals[a.ID] = agent.Approval{Approved: a.Approved, Reason: a.Reason}
+		}
+		slog.Info("resuming chat after approval", "approvals", len(approvals))
+		run = func() error { return agt.Resume(r.Context(), approvals, w) }
+	} else {
+		msg, err := req.toMessage()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		if s.store != nil && req.ID != "" {
+			if err := store.ValidateID(req.ID); err != nil {
+				http.Error(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
+				return
+			}
+		}
+
+		defer s.lockConversation(req.ID)()
+		if s.hasPending(req.ID) {
+			http.Error(w, "Conversation is waiting for tool approvals", http.StatusConflict)
+			return
+		}
+
+		slog.Info("handling chat request", "id", req.ID, "message", req.Message, "parts", len(req.Parts))
+		agt = s.newAgent(req.ID)
+		run = func() error { return agt.ChatMessage(r.Context(), msg, w) }
+	}
 
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
@@ -56,12 +216,20 @@ func (s *server) handleChat(w http.ResponseWriter, r *http.Request) {
 		flusher.Flush()
 	}
 
-	agt := agent.New().WithProvider(s.provider)
-	for _, t := range s.tools {
-		agt = agt.WithTool(t)
-	}
-
-	if err := agt.Chat(req.Message, w); err != nil {
+	if err := run(); err != nil {
+		if r.Context().Err() != nil {
+			slog.Info("chat request cancelled by client", "error", err)
+			return
+		}
+		if errors.Is(err, agent.ErrApprovalPending) {
+			s.addPending(agt, req.ID)
+			return
+		}
+		// The stream was already closed with a finish event carrying the reason.
+		if errors.Is(err, agent.ErrOutputTruncated) || errors.Is(err, agent.ErrGenerationFailed) || errors.Is(err, agent.ErrStopped) {
+			slog.Warn("agent chat ended early", "error", err)
+			return
+		}
 		slog.Error("agent chat failed", "error", err)
 		fmt.Fprintf(w, "data: {\"type\":\"error\",\"error\":\"%s\"}\n\n", err.Error())
 		if flusher, ok := w.(http.Flusher); ok {
@@ -70,6 +238,112 @@ func (s *server) handleChat(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (s *server) newAgent(id string) agent.Agent {
+	agt := agent.New().
+		WithProvider(s.provider).
+		WithToolConcurrency(s.concurrency).
+		WithApprover(agent.DeferApproval)
+	for _, t := range s.tools {
+		agt = agt.WithTool(t)
+	}
+	if s.instructions != nil {
+		agt = agt.WithInstructionsFunc(s.instructions)
+	}
+	if s.store != nil && id != "" {
+		agt = agt.WithConversationStore(s.store, id)
+	}
+	return agt
+}
+
+func (s *server) addPending(agt agent.Agent, conversation string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for id, p := range s.pending {
+		if now.After(p.expires) {
+			delete(s.pending, id)
+		}
+	}
+
+	p := &pendingChat{agent: agt, conversation: conversation, expires: now.Add(approvalTimeout)}
+	for _, req := range agt.PendingApprovals() {
+		s.pending[req.ID] = p
+	}
+}
+
+// hasPending reports whether the conversation has a turn waiting for
+// approvals.
+func (s *server) hasPending(conversation string) bool {
+	if conversation == "" {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for _, p := range s.pending {
+		if p.conversation == conversation && now.Before(p.expires) {
+			return true
+		}
+	}
+	return false
+}
+
+// takePending removes the agent waiting on the approval and locks its
+// conversation, or returns nil if no agent is waiting on it. The lock is
+// taken before the agent is removed, so no new message can run in between.
+func (s *server) takePending(approvalID string) (agent.Agent, func()) {
+	s.mu.Lock()
+	p, ok := s.pending[approvalID]
+	s.mu.Unlock()
+	if !ok {
+		return nil, nil
+	}
+
+	unlock := s.lockConversation(p.conversation)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pending[approvalID] != p || time.Now().After(p.expires) {
+		unlock()
+		return nil, nil
+	}
+	for _, req := range p.agent.PendingApprovals() {
+		delete(s.pending, req.ID)
+	}
+	return p.agent, unlock
+}
+
+// lockConversation holds the conversation until the returned function is
+// called. Requests without an ID share no history and are not serialized.
+func (s *server) lockConversation(id string) func() {
+	if id == "" {
+		return func() {}
+	}
+
+	s.mu.Lock()
+	l, ok := s.locks[id]
+	if !ok {
+		l = &conversationLock{}
+		s.locks[id] = l
+	}
+	l.refs++
+	s.mu.Unlock()
+
+	l.mu.Lock()
+	return func() {
+		l.mu.Unlock()
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if l.refs--; l.refs == 0 {
+			delete(s.locks, id)
+		}
+	}
+}
+
 func (s *server) Start(addr string) error {
 	http.HandleFunc("POST /chat", s.handleChat)
 	http.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/alexisbouchez/palm/provider"
+	"github.com/alexisbouchez/palm/provider/providertest"
+	"github.com/alexisbouchez/palm/store"
+	"github.com/alexisbouchez/palm/tool"
+)
+
+type deleteInput struct {
+	Path string `json:"path"`
+}
+
+func post(t *testing.T, s *server, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	s.handleChat(w, httptest.NewRequest(http.MethodPost, "/chat", strings.NewReader(body)))
+	return w
+}
+
+func TestApprovalPausesConversation(t *testing.T) {
+	var deleted atomic.Int32
+	del := tool.New[deleteInput]().
+		WithName("delete").
+		WithNeedsApproval(true).
+		WithExecute(func(deleteInput) (string, error) {
+			deleted.Add(1)
+			return "deleted", nil
+		})
+
+	fake := providertest.NewFake(
+		providertest.ToolCall("delete", deleteInput{Path: "notes.txt"}),
+		providertest.Text("Done."),
+	)
+	conversations := store.NewMemory()
+	s := New(fake, []tool.Callable{del}).WithConversationStore(conversations).(*server)
+
+	w := post(t, s, `{"id":"c1","message":"delete my notes"}`)
+	match := regexp.MustCompile(`"approvalId":"([^"]+)"`).FindStringSubmatch(w.Body.String())
+	if match == nil {
+		t.Fatalf("no tool-approval-request in:\n%s", w.Body.String())
+	}
+
+	saved, err := conversations.Load(context.Background(), "c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved) != 0 {
+		t.Errorf("saved %d messages while approval is pending", len(saved))
+	}
+
+	if w := post(t, s, `{"id":"c1","message":"hello?"}`); w.Code != http.StatusConflict {
+		t.Errorf("new message while pending: status %d, want %d", w.Code, http.StatusConflict)
+	}
+
+	if w := post(t, s, `{"approvals":[{"id":"`+match[1]+`","approved":true}]}`); w.Code != http.StatusOK {
+		t.Fatalf("resume: status %d: %s", w.Code, w.Body.String())
+	}
+	if deleted.Load() != 1 {
+		t.Errorf("tool ran %d times, want 1", deleted.Load())
+	}
+
+	saved, err = conversations.Load(context.Background(), "c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var roles []string
+	for _, m := range saved {
+		roles = append(roles, m.Role)
+	}
+	if got := strings.Join(roles, ","); got != "user,assistant,tool,assistant" {
+		t.Errorf("saved roles = %s", got)
+	}
+
+	if w := post(t, s, `{"approvals":[{"id":"`+match[1]+`","approved":true}]}`); w.Code != http.StatusNotFound {
+		t.Errorf("second resume: status %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestToMessageParts(t *testing.T) {
+	req := ChatRequest{
+		Message: "what are these?",
+		Parts: []MessagePart{
+			{Type: "file", MediaType: "image/png", Filename: "shot.png", URL: "data:image/png;base64,AQ=="},
+			{Type: "file", MediaType: "image/jpeg", URL: "https://example.com/cat.jpg"},
+			{Type: "file", MediaType: "application/pdf", Filename: "report.pdf", URL: "https://example.com/report.pdf"},
+			{Type: "text", Text: "thanks"},
+		},
+	}
+	msg, err := req.toMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	shot := provider.DataPart("image/png", []byte{1})
+	shot.Name = "shot.png"
+	want := provider.Message{Role: "user", Parts: []provider.ContentPart{
+		provider.TextPart("what are these?"),
+		shot,
+		provider.ImageURLPart("https://example.com/cat.jpg"),
+		provider.DocumentURLPart("https://example.com/report.pdf", "report.pdf"),
+		provider.TextPart("thanks"),
+	}}
+	if !reflect.DeepEqual(msg, want) {
+		t.Errorf("message = %+v\nwant %+v", msg, want)
+	}
+
+	if msg, err := (ChatRequest{Message: "hi"}).toMessage(); err != nil || msg.Content != "hi" || msg.Parts != nil {
+		t.Errorf("plain message = %+v, %v", msg, err)
+	}
+	if _, err := (ChatRequest{Parts: []MessagePart{{Type: "audio"}}}).toMessage(); err == nil {
+		t.Error("unsupported part type accepted")
+	}
+}
+
+func TestRejectsBadParts(t *testing.T) {
+	s := New(providertest.NewFake(), nil).(*server)
+	if w := post(t, s, `{"message":"hi","parts":[{"type":"audio"}]}`); w.Code != http.StatusBadRequest {
+		t.Errorf("status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestInstructions(t *testing.T) {
+	fake := providertest.NewFake(providertest.Text("Hello."), providertest.Text("Again."))
+	conversations := store.NewMemory()
+	s := New(fake, nil).WithInstructions("You are a weather bot.").WithConversationStore(conversations).(*server)
+
+	for range 2 {
+		if w := post(t, s, `{"id":"c1","message":"hi"}`); w.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", w.Code, w.Body.String())
+		}
+		first := fake.LastRequest().Messages[0]
+		if first.Role != "system" || first.Content != "You are a weather bot." {
+			t.Errorf("request starts with %+v, want the instructions", first)
+		}
+	}
+
+	saved, err := conversations.Load(context.Background(), "c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range saved {
+		if m.Role == "system" {
+			t.Errorf("instructions stored in the conversation: %+v", saved)
+		}
+	}
+}
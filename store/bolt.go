@@ -0,0 +1,93 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/alexisbouchez/palm/provider"
+	bolt "go.etcd.io/bbolt"
+)
+
+var conversationsBucket = []byte("conversations")
+
+type boltStore struct {
+	db *bolt.DB
+}
+
+// NewBolt returns a store backed by a bbolt database file. Only one process
+// can hold the file open at a time; Close releases it.
+func NewBolt(path string) (ConversationStore, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open database: %w", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(conversationsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("create bucket: %w", err)
+	}
+
+	return &boltStore{db: db}, nil
+}
+
+func (b *boltStore) Load(ctx context.Context, id string) ([]provider.Message, error) {
+	if err := ValidateID(id); err != nil {
+		return nil, err
+	}
+
+	var messages []provider.Message
+	err := b.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(conversationsBucket).Get([]byte(id))
+		if data == nil {
+			return nil
+		}
+		return json.Unmarshal(data, &messages)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("load conversation %s: %w", id, err)
+	}
+	return messages, nil
+}
+
+func (b *boltStore) Save(ctx context.Context, id string, messages []provider.Message) error {
+	if err := ValidateID(id); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(messages)
+	if err != nil {
+		return fmt.Errorf("encode conversation: %w", err)
+	}
+
+	err = b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(conversationsBucket).Put([]byte(id), data)
+	})
+	if err != nil {
+		return fmt.Errorf("save conversation %s: %w", id, err)
+	}
+	return nil
+}
+
+func (b *boltStore) Delete(ctx context.Context, id string) error {
+	if err := ValidateID(id); err != nil {
+		return err
+	}
+
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(conversationsBucket).Delete([]byte(id))
+	})
+	if err != nil {
+		return fmt.Errorf("delete conversation %s: %w", id, err)
+	}
+	return nil
+}
+
+func (b *boltStore) Close() error {
+	return b.db.Close()
+}
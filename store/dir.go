@@ -0,0 +1,113 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/alexisbouchez/palm/provider"
+)
+
+type dir struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewDir returns a store that keeps each conversation in <path>/<id>.json,
+// creating the directory if needed.
+func NewDir(path string) (ConversationStore, error) {
+	if err := os.MkdirAll(path, 0o700); err != nil {
+		return nil, fmt.Errorf("create store directory: %w", err)
+	}
+	return &dir{path: path}, nil
+}
+
+func (d *dir) file(id string) (string, error) {
+	if err := ValidateID(id); err != nil {
+		return "", err
+	}
+	return filepath.Join(d.path, id+".json"), nil
+}
+
+func (d *dir) Load(ctx context.Context, id string) ([]provider.Message, error) {
+	name, err := d.file(id)
+	if err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	data, err := os.ReadFile(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read conversation: %w", err)
+	}
+
+	var messages []provider.Message
+	if err := json.Unmarshal(data, &messages); err != nil {
+		return nil, fmt.Errorf("decode conversation %s: %w", id, err)
+	}
+	return messages, nil
+}
+
+// Save writes to a temporary file first so a crash never leaves a
+// half-written conversation behind.
+func (d *dir) Save(ctx context.Context, id string, messages []provider.Message) error {
+	name, err := d.file(id)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(messages, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode conversation: %w", err)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	tmp, err := os.CreateTemp(d.path, id+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("write conversation: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write conversation: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("write conversation: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), name); err != nil {
+		return fmt.Errorf("write conversation: %w", err)
+	}
+	return nil
+}
+
+func (d *dir) Delete(ctx context.Context, id string) error {
+	name, err := d.file(id)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := os.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("delete conversation: %w", err)
+	}
+	return nil
+}
+
+func (d *dir) Close() error {
+	return nil
+}
@@ -0,0 +1,76 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"sync"
+
+	"github.com/alexisbouchez/palm/provider"
+)
+
+// ConversationStore keeps the message history of conversations by ID. Load
+// returns an empty history for IDs that were never saved.
+type ConversationStore interface {
+	Load(ctx context.Context, id string) ([]provider.Message, error)
+	Save(ctx context.Context, id string, messages []provider.Message) error
+	Delete(ctx context.Context, id string) error
+	Close() error
+}
+
+var ErrInvalidID = errors.New("invalid conversation id")
+
+var validID = regexp.MustCompile(`^[A-Za-z0-9_-]{1,128}$`)
+
+// ValidateID rejects IDs that could not safely be used as a file name or key.
+func ValidateID(id string) error {
+	if !validID.MatchString(id) {
+		return fmt.Errorf("%w: %q", ErrInvalidID, id)
+	}
+	return nil
+}
+
+type memory struct {
+	mu            sync.Mutex
+	conversations map[string][]provider.Message
+}
+
+// NewMemory returns a store that lives as long as the process.
+func NewMemory() ConversationStore {
+	return &memory{conversations: map[string][]provider.Message{}}
+}
+
+func (m *memory) Load(ctx context.Context, id string) ([]provider.Message, error) {
+	if err := ValidateID(id); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.conversations[id]), nil
+}
+
+func (m *memory) Save(ctx context.Context, id string, messages []provider.Message) error {
+	if err := ValidateID(id); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.conversations[id] = slices.Clone(messages)
+	return nil
+}
+
+func (m *memory) Delete(ctx context.Context, id string) error {
+	if err := ValidateID(id); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.conversations, id)
+	return nil
+}
+
+func (m *memory) Close() error {
+	return nil
+}
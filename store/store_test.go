@@ -0,0 +1,195 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/alexisbouchez/palm/provider"
+)
+
+func conversation() []provider.Message {
+	return []provider.Message{
+		{Role: "user", Parts: []provider.ContentPart{provider.TextPart("what is this?"), provider.ImageURLPart("https://example.com/cat.jpg")}},
+		{Role: "assistant", ToolCalls: []provider.ToolCall{{ID: "call_1", Type: "function", Function: provider.FunctionCall{Name: "lookup", Arguments: `{"q":"cat"}`}}}},
+		{Role: "tool", Content: "a cat", ToolCallID: "call_1"},
+		{Role: "assistant", Content: "A cat."},
+	}
+}
+
+func stores(t *testing.T) map[string]ConversationStore {
+	t.Helper()
+
+	d, err := NewDir(filepath.Join(t.TempDir(), "conversations"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewBolt(filepath.Join(t.TempDir(), "palm.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { b.Close() })
+
+	return map[string]ConversationStore{"memory": NewMemory(), "dir": d, "bolt": b}
+}
+
+func TestRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			if messages, err := s.Load(ctx, "missing"); err != nil || len(messages) != 0 {
+				t.Errorf("Load of a missing ID = %+v, %v, want an empty history", messages, err)
+			}
+
+			if err := s.Save(ctx, "c1", conversation()); err != nil {
+				t.Fatal(err)
+			}
+			got, err := s.Load(ctx, "c1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, conversation()) {
+				t.Errorf("loaded %+v\nwant %+v", got, conversation())
+			}
+
+			short := conversation()[:1]
+			if err := s.Save(ctx, "c1", short); err != nil {
+				t.Fatal(err)
+			}
+			if got, err := s.Load(ctx, "c1"); err != nil || !reflect.DeepEqual(got, short) {
+				t.Errorf("after overwrite loaded %+v, %v, want %+v", got, err, short)
+			}
+
+			if err := s.Delete(ctx, "c1"); err != nil {
+				t.Fatal(err)
+			}
+			if got, err := s.Load(ctx, "c1"); err != nil || len(got) != 0 {
+				t.Errorf("after delete loaded %+v, %v", got, err)
+			}
+			if err := s.Delete(ctx, "c1"); err != nil {
+				t.Errorf("deleting a missing ID: %v", err)
+			}
+		})
+	}
+}
+
+// Loaded histories are copies: changing one does not change the store.
+func TestLoadReturnsCopy(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range stores(t) {
+		if err := s.Save(ctx, "c1", conversation()); err != nil {
+			t.Fatal(err)
+		}
+		got, _ := s.Load(ctx, "c1")
+		got[0].Role = "changed"
+		if again, _ := s.Load(ctx, "c1"); again[0].Role != "user" {
+			t.Errorf("%s: store changed through a loaded history", name)
+		}
+	}
+}
+
+func TestInvalidIDs(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range stores(t) {
+		for _, id := range []string{"", "../etc/passwd", "a/b", "dot.json", strings.Repeat("x", 129)} {
+			if _, err := s.Load(ctx, id); !errors.Is(err, ErrInvalidID) {
+				t.Errorf("%s: Load(%q) err = %v, want ErrInvalidID", name, id, err)
+			}
+			if err := s.Save(ctx, id, conversation()); !errors.Is(err, ErrInvalidID) {
+				t.Errorf("%s: Save(%q) err = %v, want ErrInvalidID", name, id, err)
+			}
+			if err := s.Delete(ctx, id); !errors.Is(err, ErrInvalidID) {
+				t.Errorf("%s: Delete(%q) err = %v, want ErrInvalidID", name, id, err)
+			}
+		}
+	}
+}
+
+func TestValidateID(t *testing.T) {
+	for _, id := range []string{"c1", "A-z_0-9", strings.Repeat("x", 128)} {
+		if err := ValidateID(id); err != nil {
+			t.Errorf("ValidateID(%q) = %v", id, err)
+		}
+	}
+	for _, id := range []string{"", " ", "a b", "..", "a/b", `a\b`, "é", strings.Repeat("x", 129)} {
+		if err := ValidateID(id); !errors.Is(err, ErrInvalidID) {
+			t.Errorf("ValidateID(%q) = %v, want ErrInvalidID", id, err)
+		}
+	}
+}
+
+// Saves go through a temporary file that is renamed into place, so only the
+// final file is left behind and it always holds a complete history.
+func TestDirSaveIsAtomic(t *testing.T) {
+	path := t.TempDir()
+	s, err := NewDir(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	for _, messages := range [][]provider.Message{conversation(), conversation()[:1]} {
+		if err := s.Save(ctx, "c1", messages); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if !reflect.DeepEqual(names, []string{"c1.json"}) {
+		t.Errorf("files = %v, want only c1.json", names)
+	}
+
+	if got, err := s.Load(ctx, "c1"); err != nil || len(got) != 1 {
+		t.Errorf("loaded %+v, %v, want the last history", got, err)
+	}
+}
+
+func TestDirCorruptFile(t *testing.T) {
+	path := t.TempDir()
+	s, err := NewDir(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(path, "c1.json"), []byte(`[{"role":`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Load(context.Background(), "c1"); err == nil {
+		t.Error("want an error for a half-written file")
+	}
+}
+
+func TestBoltPersistsAcrossOpens(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "palm.db")
+	ctx := context.Background()
+
+	s, err := NewBolt(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save(ctx, "c1", conversation()); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = NewBolt(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if got, err := s.Load(ctx, "c1"); err != nil || !reflect.DeepEqual(got, conversation()) {
+		t.Errorf("loaded %+v, %v after reopening", got, err)
+	}
+}
@@ -0,0 +1,100 @@
+package stream
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+)
+
+// StepWriter folds the complete message streams written by successive
+// provider calls into the steps of a single message: the first start event
+// is kept and every call is wrapped in start-step/finish-step. Finish and
+// [DONE] are dropped so the caller can close the message itself.
+type StepWriter struct {
+	writer  io.Writer
+	emitter *Emitter
+	buffer  []byte
+	started bool
+}
+
+func NewStepWriter(w io.Writer) *StepWriter {
+	return &StepWriter{
+		writer:  w,
+		emitter: NewEmitter(w),
+	}
+}
+
+func (s *StepWriter) Write(p []byte) (int, error) {
+	s.buffer = append(s.buffer, p...)
+
+	for {
+		idx := bytes.Index(s.buffer, []byte("\n\n"))
+		if idx == -1 {
+			break
+		}
+
+		frame := s.buffer[:idx+2]
+		s.buffer = s.buffer[idx+2:]
+
+		if err := s.writeFrame(frame); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
+
+func (s *StepWriter) writeFrame(frame []byte) error {
+	data, ok := bytes.CutPrefix(bytes.TrimSpace(frame), []byte("data: "))
+	if !ok {
+		return s.passThrough(frame)
+	}
+
+	if string(data) == EventDone {
+		return nil
+	}
+
+	var event struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(data, &event); err != nil {
+		return s.passThrough(frame)
+	}
+
+	switch event.Type {
+	case EventStart:
+		if !s.started {
+			s.started = true
+			if err := s.passThrough(frame); err != nil {
+				return err
+			}
+		}
+		return s.emitter.StartStep()
+	case EventFinish:
+		return s.emitter.FinishStep()
+	default:
+		return s.passThrough(frame)
+	}
+}
+
+// Start opens the message without a provider call, for turns that resume
+// with tool results before the model is called again.
+func (s *StepWriter) Start() error {
+	if s.started {
+		return nil
+	}
+	s.started = true
+	return s.emitter.Start("")
+}
+
+func (s *StepWriter) passThrough(frame []byte) error {
+	if _, err := s.writer.Write(frame); err != nil {
+		return err
+	}
+	s.emitter.flush()
+	return nil
+}
+
+func (s *StepWriter) Flush() {
+	s.emitter.flush()
+}
@@ -0,0 +1,89 @@
+package stream
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func types(t *testing.T, out string) string {
+	t.Helper()
+
+	var got []string
+	for _, frame := range strings.Split(strings.TrimSuffix(out, "\n\n"), "\n\n") {
+		data := strings.TrimPrefix(frame, "data: ")
+		if data == EventDone {
+			got = append(got, data)
+			continue
+		}
+		_, rest, ok := strings.Cut(data, `"type":"`)
+		if !ok {
+			t.Fatalf("frame without a type: %q", frame)
+		}
+		typ, _, _ := strings.Cut(rest, `"`)
+		got = append(got, typ)
+	}
+	return strings.Join(got, ",")
+}
+
+func TestStepWriterJoinsProviderStreams(t *testing.T) {
+	var out bytes.Buffer
+	steps := NewStepWriter(&out)
+
+	for range 2 {
+		e := NewEmitter(steps)
+		e.Start("msg")
+		e.TextStart("t")
+		e.TextDelta("t", "hi")
+		e.TextEnd("t")
+		e.Finish("stop", map[string]any{"usage": 1})
+		e.Done()
+	}
+
+	want := "start,start-step,text-start,text-delta,text-end,finish-step,start-step,text-start,text-delta,text-end,finish-step"
+	if got := types(t, out.String()); got != want {
+		t.Errorf("events = %s\nwant %s", got, want)
+	}
+}
+
+func TestStepWriterSplitWrites(t *testing.T) {
+	var out bytes.Buffer
+	steps := NewStepWriter(&out)
+
+	stream := `data: {"type":"start"}` + "\n\n" + `data: {"type":"text-start","id":"t"}` + "\n\n" + `data: {"type":"finish"}` + "\n\n"
+	for i := range len(stream) {
+		steps.Write([]byte{stream[i]})
+	}
+
+	if got := types(t, out.String()); got != "start,start-step,text-start,finish-step" {
+		t.Errorf("events = %s", got)
+	}
+}
+
+func TestStepWriterStart(t *testing.T) {
+	var out bytes.Buffer
+	steps := NewStepWriter(&out)
+
+	steps.Start()
+	NewEmitter(steps).Start("msg")
+
+	if got := types(t, out.String()); got != "start,start-step" {
+		t.Errorf("events = %s, want a single start", got)
+	}
+}
+
+func TestFinishCarriesReasonAndMetadata(t *testing.T) {
+	var out bytes.Buffer
+	NewEmitter(&out).Finish("length", map[string]any{"usage": map[string]int{"total_tokens": 7}})
+
+	want := `data: {"finishReason":"length","messageMetadata":{"usage":{"total_tokens":7}},"type":"finish"}` + "\n\n"
+	if out.String() != want {
+		t.Errorf("finish = %q, want %q", out.String(), want)
+	}
+
+	out.Reset()
+	NewEmitter(&out).Finish("", nil)
+	if out.String() != `data: {"type":"finish"}`+"\n\n" {
+		t.Errorf("bare finish = %q", out.String())
+	}
+}
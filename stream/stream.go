@@ -28,13 +28,21 @@ func (e *Emitter) flush() {
 
 const (
 	EventStart               = "start"
+	EventStartStep           = "start-step"
 	EventTextStart           = "text-start"
 	EventTextDelta           = "text-delta"
 	EventTextEnd             = "text-end"
+	EventReasoningStart      = "reasoning-start"
+	EventReasoningDelta      = "reasoning-delta"
+	EventReasoningEnd        = "reasoning-end"
 	EventToolInputStart      = "tool-input-start"
 	EventToolInputDelta      = "tool-input-delta"
 	EventToolInputAvailable  = "tool-input-available"
 	EventToolOutputAvailable = "tool-output-available"
+	EventToolApprovalRequest = "tool-approval-request"
+	EventToolOutputDenied    = "tool-output-denied"
+	EventMessageMetadata     = "message-metadata"
+	EventFinishStep          = "finish-step"
 	EventFinish              = "finish"
 	EventError               = "error"
 	EventDone                = "[DONE]"
@@ -87,6 +95,28 @@ func (e *Emitter) TextEnd(id string) error {
 	})
 }
 
+func (e *Emitter) ReasoningStart(id string) error {
+	return e.emit(map[string]any{
+		"type": EventReasoningStart,
+		"id":   id,
+	})
+}
+
+func (e *Emitter) ReasoningDelta(id string, delta string) error {
+	return e.emit(map[string]any{
+		"type":  EventReasoningDelta,
+		"id":    id,
+		"delta": delta,
+	})
+}
+
+func (e *Emitter) ReasoningEnd(id string) error {
+	return e.emit(map[string]any{
+		"type": EventReasoningEnd,
+		"id":   id,
+	})
+}
+
 func (e *Emitter) ToolInputStart(toolCallID, toolName string) error {
 	return e.emit(map[string]any{
 		"type":       EventToolInputStart,
@@ -120,12 +150,53 @@ func (e *Emitter) ToolOutputAvailable(toolCallID string, output any) error {
 	})
 }
 
-func (e *Emitter) Finish() error {
+func (e *Emitter) ToolApprovalRequest(approvalID, toolCallID string) error {
 	return e.emit(map[string]any{
-		"type": EventFinish,
+		"type":       EventToolApprovalRequest,
+		"approvalId": approvalID,
+		"toolCallId": toolCallID,
 	})
 }
 
+func (e *Emitter) ToolOutputDenied(toolCallID string) error {
+	return e.emit(map[string]any{
+		"type":       EventToolOutputDenied,
+		"toolCallId": toolCallID,
+	})
+}
+
+func (e *Emitter) MessageMetadata(metadata any) error {
+	return e.emit(map[string]any{
+		"type":            EventMessageMetadata,
+		"messageMetadata": metadata,
+	})
+}
+
+func (e *Emitter) StartStep() error {
+	return e.emit(map[string]any{
+		"type": EventStartStep,
+	})
+}
+
+func (e *Emitter) FinishStep() error {
+	return e.emit(map[string]any{
+		"type": EventFinishStep,
+	})
+}
+
+func (e *Emitter) Finish(finishReason string, metadata any) error {
+	data := map[string]any{
+		"type": EventFinish,
+	}
+	if finishReason != "" {
+		data["finishReason"] = finishReason
+	}
+	if metadata != nil {
+		data["messageMetadata"] = metadata
+	}
+	return e.emit(data)
+}
+
 func (e *Emitter) Error(errorText string) error {
 	return e.emit(map[string]any{
 		"type":      EventError,
@@ -1,7 +1,9 @@
 package tool
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"reflect"
 	"strings"
 )
@@ -11,6 +13,19 @@ type Callable interface {
 	GetDescription() string
 	GetParameters() json.RawMessage
 	Call(input json.RawMessage) (string, error)
+	CallContext(ctx context.Context, input json.RawMessage) (string, error)
+}
+
+// SerialOnly is implemented by tools that must never run at the same time as
+// another tool call, for instance because they change shared state.
+type SerialOnly interface {
+	SerialOnly() bool
+}
+
+// NeedsApproval is implemented by tools that must not run until a person has
+// approved the call, such as tools that write files or spend money.
+type NeedsApproval interface {
+	NeedsApproval() bool
 }
 
 type Tool[T any] interface {
@@ -18,12 +33,18 @@ type Tool[T any] interface {
 	WithName(string) Tool[T]
 	WithDescription(string) Tool[T]
 	WithExecute(func(T) (string, error)) Tool[T]
+	WithExecuteContext(func(context.Context, T) (string, error)) Tool[T]
+	WithSerialOnly(serial bool) Tool[T]
+	WithNeedsApproval(needsApproval bool) Tool[T]
 }
 
 type tool[T any] struct {
-	name        string
-	description string
-	execute     func(input T) (string, error)
+	name           string
+	description    string
+	execute        func(input T) (string, error)
+	executeContext func(ctx context.Context, input T) (string, error)
+	serialOnly     bool
+	needsApproval  bool
 }
 
 func New[T any]() Tool[T] {
@@ -45,6 +66,29 @@ func (t *tool[T]) WithExecute(fn func(input T) (string, error)) Tool[T] {
 	return t
 }
 
+func (t *tool[T]) WithExecuteContext(fn func(ctx context.Context, input T) (string, error)) Tool[T] {
+	t.executeContext = fn
+	return t
+}
+
+func (t *tool[T]) WithSerialOnly(serial bool) Tool[T] {
+	t.serialOnly = serial
+	return t
+}
+
+func (t *tool[T]) SerialOnly() bool {
+	return t.serialOnly
+}
+
+func (t *tool[T]) WithNeedsApproval(needsApproval bool) Tool[T] {
+	t.needsApproval = needsApproval
+	return t
+}
+
+func (t *tool[T]) NeedsApproval() bool {
+	return t.needsApproval
+}
+
 func (t *tool[T]) GetName() string {
 	return t.name
 }
@@ -54,18 +98,37 @@ func (t *tool[T]) GetDescription() string {
 }
 
 func (t *tool[T]) GetParameters() json.RawMessage {
-	var input T
-	schema := schemaFromType(reflect.TypeOf(input))
+	return Schema[T]()
+}
+
+// Schema returns the JSON schema derived from T, the same one tools built
+// with New[T] advertise for their input.
+func Schema[T any]() json.RawMessage {
+	schema := schemaFromType(reflect.TypeFor[T]())
 	b, _ := json.Marshal(schema)
 	return b
 }
 
 func (t *tool[T]) Call(input json.RawMessage) (string, error) {
+	return t.CallContext(context.Background(), input)
+}
+
+func (t *tool[T]) CallContext(ctx context.Context, input json.RawMessage) (string, error) {
 	var parsed T
 	if err := json.Unmarshal(input, &parsed); err != nil {
 		return "", err
 	}
-	return t.execute(parsed)
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	switch {
+	case t.executeContext != nil:
+		return t.executeContext(ctx, parsed)
+	case t.execute != nil:
+		return t.execute(parsed)
+	default:
+		return "", errors.New("execute undefined")
+	}
 }
 
 func schemaFromType(t reflect.Type) map[string]any {
@@ -73,6 +136,23 @@ func schemaFromType(t reflect.Type) map[string]any {
 		t = t.Elem()
 	}
 
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": schemaFromType(t.Elem())}
+	case reflect.Struct:
+	default:
+		return map[string]any{}
+	}
+
 	schema := map[string]any{"type": "object"}
 	props := map[string]any{}
 	var required []string
@@ -88,28 +168,7 @@ func schemaFromType(t reflect.Type) map[string]any {
 			name = strings.ToLower(field.Name)
 		}
 
-		prop := map[string]any{}
-
-		switch field.Type.Kind() {
-		case reflect.String:
-			prop["type"] = "string"
-		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
-			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-			prop["type"] = "integer"
-		case reflect.Float32, reflect.Float64:
-			prop["type"] = "number"
-		case reflect.Bool:
-			prop["type"] = "boolean"
-		case reflect.Slice:
-			prop["type"] = "array"
-			if elem := field.Type.Elem(); elem.Kind() == reflect.Struct {
-				prop["items"] = schemaFromType(elem)
-			} else {
-				prop["items"] = schemaFromType(elem)
-			}
-		case reflect.Struct:
-			prop = schemaFromType(field.Type)
-		}
+		prop := schemaFromType(field.Type)
 
 		if desc := field.Tag.Get("description"); desc != "" {
 			prop["description"] = desc
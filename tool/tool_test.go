@@ -0,0 +1,91 @@
+package tool
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func schemaOf[T any](t *testing.T) map[string]any {
+	t.Helper()
+	var s map[string]any
+	if err := json.Unmarshal(Schema[T](), &s); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestSchemaScalarsAndSlices(t *testing.T) {
+	tests := []struct {
+		got  map[string]any
+		want map[string]any
+	}{
+		{schemaOf[string](t), map[string]any{"type": "string"}},
+		{schemaOf[int64](t), map[string]any{"type": "integer"}},
+		{schemaOf[uint8](t), map[string]any{"type": "integer"}},
+		{schemaOf[float32](t), map[string]any{"type": "number"}},
+		{schemaOf[bool](t), map[string]any{"type": "boolean"}},
+		{schemaOf[*string](t), map[string]any{"type": "string"}},
+		{schemaOf[[]int](t), map[string]any{"type": "array", "items": map[string]any{"type": "integer"}}},
+		{schemaOf[[2][]string](t), map[string]any{"type": "array", "items": map[string]any{"type": "array", "items": map[string]any{"type": "string"}}}},
+		{schemaOf[map[string]int](t), map[string]any{}},
+	}
+	for _, tt := range tests {
+		if !reflect.DeepEqual(tt.got, tt.want) {
+			t.Errorf("schema = %v, want %v", tt.got, tt.want)
+		}
+	}
+}
+
+type searchInput struct {
+	Query   string   `json:"query" description:"What to look for" required:"true"`
+	Limit   *int     `json:"limit,omitempty"`
+	Filters []filter `json:"filters"`
+	Verbose bool
+}
+
+type filter struct {
+	Field string `json:"field" required:"true"`
+}
+
+func TestSchemaStruct(t *testing.T) {
+	want := map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"query": map[string]any{"type": "string", "description": "What to look for"},
+			"limit": map[string]any{"type": "integer"},
+			"filters": map[string]any{"type": "array", "items": map[string]any{
+				"type":       "object",
+				"properties": map[string]any{"field": map[string]any{"type": "string"}},
+				"required":   []any{"field"},
+			}},
+			"verbose": map[string]any{"type": "boolean"},
+		},
+		"required": []any{"query"},
+	}
+	if got := schemaOf[searchInput](t); !reflect.DeepEqual(got, want) {
+		t.Errorf("schema = %v\nwant %v", got, want)
+	}
+
+	var params map[string]any
+	json.Unmarshal(New[searchInput]().GetParameters(), &params)
+	if !reflect.DeepEqual(params, want) {
+		t.Error("tool parameters differ from Schema")
+	}
+}
+
+func TestCallDecodesInput(t *testing.T) {
+	search := New[searchInput]().WithExecute(func(in searchInput) (string, error) {
+		return in.Query, nil
+	})
+	if got, err := search.Call(json.RawMessage(`{"query":"palm"}`)); err != nil || got != "palm" {
+		t.Errorf("Call = %q, %v", got, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := search.CallContext(ctx, json.RawMessage(`{"query":"palm"}`)); err == nil {
+		t.Error("CallContext ran with a cancelled context")
+	}
+}
@@ -0,0 +1,84 @@
+package tool
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+)
+
+// Validate checks that data is JSON matching schema. Only the subset of JSON
+// schema produced by Schema is understood: types, properties, items and
+// required.
+func Validate(schema, data json.RawMessage) error {
+	var s map[string]any
+	if err := json.Unmarshal(schema, &s); err != nil {
+		return fmt.Errorf("parse schema: %w", err)
+	}
+
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return fmt.Errorf("invalid json: %w", err)
+	}
+
+	return validate(s, v, "$")
+}
+
+func validate(schema map[string]any, v any, path string) error {
+	// Null decodes to the zero value, so it is accepted for any type.
+	if v == nil {
+		return nil
+	}
+
+	switch schema["type"] {
+	case "object":
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: expected object", path)
+		}
+		if required, ok := schema["required"].([]any); ok {
+			for _, name := range required {
+				if _, ok := obj[name.(string)]; !ok {
+					return fmt.Errorf("%s: missing required field %q", path, name)
+				}
+			}
+		}
+		props, _ := schema["properties"].(map[string]any)
+		for name, value := range obj {
+			prop, ok := props[name].(map[string]any)
+			if !ok {
+				continue
+			}
+			if err := validate(prop, value, path+"."+name); err != nil {
+				return err
+			}
+		}
+	case "array":
+		arr, ok := v.([]any)
+		if !ok {
+			return fmt.Errorf("%s: expected array", path)
+		}
+		items, _ := schema["items"].(map[string]any)
+		for i, item := range arr {
+			if err := validate(items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case "string":
+		if _, ok := v.(string); !ok {
+			return fmt.Errorf("%s: expected string", path)
+		}
+	case "integer":
+		if n, ok := v.(float64); !ok || n != math.Trunc(n) {
+			return fmt.Errorf("%s: expected integer", path)
+		}
+	case "number":
+		if _, ok := v.(float64); !ok {
+			return fmt.Errorf("%s: expected number", path)
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return fmt.Errorf("%s: expected boolean", path)
+		}
+	}
+	return nil
+}
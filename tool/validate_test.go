@@ -0,0 +1,54 @@
+package tool
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+type address struct {
+	City string `json:"city" required:"true"`
+	Zip  int    `json:"zip"`
+}
+
+type person struct {
+	Name    string   `json:"name" required:"true"`
+	Age     int      `json:"age"`
+	Score   float64  `json:"score"`
+	Admin   bool     `json:"admin"`
+	Tags    []string `json:"tags"`
+	Address address  `json:"address"`
+}
+
+func TestValidate(t *testing.T) {
+	schema := Schema[person]()
+
+	tests := []struct {
+		data string
+		err  string
+	}{
+		{`{"name":"Ada","age":36,"score":9.5,"admin":true,"tags":["x"],"address":{"city":"London","zip":1}}`, ""},
+		{`{"name":"Ada","age":null}`, ""},
+		{`{"name":"Ada","unknown":1}`, ""},
+		{`{"age":36}`, `$: missing required field "name"`},
+		{`{"name":7}`, "$.name: expected string"},
+		{`{"name":"Ada","age":36.5}`, "$.age: expected integer"},
+		{`{"name":"Ada","score":"high"}`, "$.score: expected number"},
+		{`{"name":"Ada","admin":"yes"}`, "$.admin: expected boolean"},
+		{`{"name":"Ada","tags":"x"}`, "$.tags: expected array"},
+		{`{"name":"Ada","tags":["x",1]}`, "$.tags[1]: expected string"},
+		{`{"name":"Ada","address":{"zip":1}}`, `$.address: missing required field "city"`},
+		{`{"name":"Ada","address":{"city":"London","zip":"N1"}}`, "$.address.zip: expected integer"},
+		{`["Ada"]`, "$: expected object"},
+		{`{"name":`, "invalid json"},
+	}
+	for _, tt := range tests {
+		err := Validate(schema, json.RawMessage(tt.data))
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("Validate(%s) = %v, want nil", tt.data, err)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("Validate(%s) = %v, want %q", tt.data, err, tt.err)
+		}
+	}
+}